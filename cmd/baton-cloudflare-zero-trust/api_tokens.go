@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newDisableAPITokenCmd returns the disable-api-token subcommand, which disables a Cloudflare API
+// token without deleting it.
+func newDisableAPITokenCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disable-api-token",
+		Short: "Disable a Cloudflare API token without deleting it",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			id, err := cmd.Flags().GetString("api-token-id")
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				return fmt.Errorf("api-token-id is required")
+			}
+
+			err = cb.DisableAPIToken(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("api token %s disabled\n", id)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("api-token-id", "", "ID of the Cloudflare API token to disable")
+
+	return cmd
+}
@@ -3,9 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/cli"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/conductorone/baton-cloudflare-zero-trust/pkg/connector"
+)
+
+const (
+	defaultServiceTokenExpiryWarning = 30 * 24 * time.Hour
+	defaultFailedLoginThreshold      = 5
+	defaultUserStatusInactiveAfter   = 90 * 24 * time.Hour
+	defaultIncrementalSyncMaxAge     = 7 * 24 * time.Hour
 )
 
 // config defines the external configuration required for the connector to run.
@@ -16,6 +28,29 @@ type config struct {
 	AccountID string `mapstructure:"account-id"`
 	Email     string `mapstructure:"email"`
 	ApiToken  string `mapstructure:"api-token"`
+
+	ServiceTokenDuration string `mapstructure:"service-token-duration"`
+	ServiceTokenGroupID  string `mapstructure:"service-token-group-id"`
+
+	ServiceTokenExpiryWarning time.Duration `mapstructure:"service-token-expiry-warning"`
+	SeatReportInactiveAfter   time.Duration `mapstructure:"seat-report-inactive-after"`
+
+	RevokeSessionsOnRevoke  bool `mapstructure:"revoke-sessions-on-revoke"`
+	RevokeDevicesOnOffboard bool `mapstructure:"revoke-devices-on-offboard"`
+	SyncActiveSessions      bool `mapstructure:"sync-active-sessions"`
+	SyncDevices             bool `mapstructure:"sync-devices"`
+
+	SyncFailedLogins     bool `mapstructure:"sync-failed-logins"`
+	FailedLoginThreshold int  `mapstructure:"failed-login-threshold"`
+
+	SyncIDPGroups             bool `mapstructure:"sync-idp-groups"`
+	SyncUserIdentityProviders bool `mapstructure:"sync-user-identity-providers"`
+
+	UserStatusRules         []string      `mapstructure:"user-status-rules"`
+	UserStatusInactiveAfter time.Duration `mapstructure:"user-status-inactive-after"`
+
+	IncrementalSync       bool          `mapstructure:"incremental-sync"`
+	IncrementalSyncMaxAge time.Duration `mapstructure:"incremental-sync-max-age"`
 }
 
 // validateConfig is run after the configuration is loaded, and should return an error if it isn't valid.
@@ -52,4 +87,142 @@ func cmdFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().String("api-key", "", "Cloudflare API key ($BATON_API_KEY)")
 	cmd.PersistentFlags().String("account-id", "", "Cloudflare account ID ($BATON_ACCOUNT_ID)")
 	cmd.PersistentFlags().String("email", "", "Cloudflare account email ($BATON_EMAIL)")
+	cmd.PersistentFlags().String("service-token-duration", "", "Duration of the service tokens created by the connector, e.g. 8760h. Defaults to the Cloudflare default ($BATON_SERVICE_TOKEN_DURATION)")
+	cmd.PersistentFlags().String("service-token-group-id", "", "Access group that service tokens created by the connector are added to ($BATON_SERVICE_TOKEN_GROUP_ID)")
+	cmd.PersistentFlags().Duration(
+		"service-token-expiry-warning",
+		defaultServiceTokenExpiryWarning,
+		"How long before their expiry service tokens are reported as expiring ($BATON_SERVICE_TOKEN_EXPIRY_WARNING)",
+	)
+	cmd.PersistentFlags().Duration(
+		"seat-report-inactive-after",
+		0,
+		"Report seats held by users inactive for longer than this during syncs. Disabled when 0 ($BATON_SEAT_REPORT_INACTIVE_AFTER)",
+	)
+	cmd.PersistentFlags().Bool(
+		"revoke-sessions-on-revoke",
+		false,
+		"End the Access sessions of users whose group membership or application access is revoked ($BATON_REVOKE_SESSIONS_ON_REVOKE)",
+	)
+	cmd.PersistentFlags().Bool(
+		"revoke-devices-on-offboard",
+		false,
+		"Revoke every WARP device of users whose last seat is revoked ($BATON_REVOKE_DEVICES_ON_OFFBOARD)",
+	)
+	cmd.PersistentFlags().Bool(
+		"sync-active-sessions",
+		false,
+		"Sync the active Access sessions of users and record them on the applications they are used on ($BATON_SYNC_ACTIVE_SESSIONS)",
+	)
+	cmd.PersistentFlags().Bool(
+		"sync-devices",
+		false,
+		"Sync WARP devices and the device counts of users. Requires the Zero Trust devices read permission ($BATON_SYNC_DEVICES)",
+	)
+	cmd.PersistentFlags().Bool("sync-failed-logins", false, "Summarise the recent failed Access logins of every user ($BATON_SYNC_FAILED_LOGINS)")
+	cmd.PersistentFlags().Int(
+		"failed-login-threshold",
+		defaultFailedLoginThreshold,
+		"Number of recent failed logins from which a user is flagged at risk. Disabled when 0 ($BATON_FAILED_LOGIN_THRESHOLD)",
+	)
+	cmd.PersistentFlags().Bool(
+		"sync-idp-groups",
+		false,
+		"Sync the identity provider groups of users from their last seen identity and link them to the Access groups including them ($BATON_SYNC_IDP_GROUPS)",
+	)
+	cmd.PersistentFlags().Bool(
+		"sync-user-identity-providers",
+		false,
+		"Attribute every user to the identity provider they last authenticated with ($BATON_SYNC_USER_IDENTITY_PROVIDERS)",
+	)
+	cmd.PersistentFlags().StringSlice(
+		"user-status-rules",
+		connector.DefaultUserStatusRules,
+		fmt.Sprintf("Rules that disable users, checked in order: %s ($BATON_USER_STATUS_RULES)", strings.Join(connector.UserStatusRules, ", ")),
+	)
+	cmd.PersistentFlags().Duration(
+		"user-status-inactive-after",
+		defaultUserStatusInactiveAfter,
+		"Inactivity after which the inactive user status rule disables users. Disabled when 0 ($BATON_USER_STATUS_INACTIVE_AFTER)",
+	)
+	cmd.PersistentFlags().Bool(
+		"incremental-sync",
+		false,
+		"Carry the grants of groups, policies and roles forward from the previous sync when the audit log records no change to them ($BATON_INCREMENTAL_SYNC)",
+	)
+	cmd.PersistentFlags().Duration(
+		"incremental-sync-max-age",
+		defaultIncrementalSyncMaxAge,
+		"How long grants are carried forward before they are synced in full again ($BATON_INCREMENTAL_SYNC_MAX_AGE)",
+	)
+}
+
+// configKeys are the connector options subcommands read from the environment.
+var configKeys = []string{
+	"api-token",
+	"api-key",
+	"account-id",
+	"email",
+	"service-token-duration",
+	"service-token-group-id",
+	"service-token-expiry-warning",
+	"seat-report-inactive-after",
+	"revoke-sessions-on-revoke",
+	"revoke-devices-on-offboard",
+	"sync-active-sessions",
+	"sync-devices",
+	"sync-failed-logins",
+	"failed-login-threshold",
+	"sync-idp-groups",
+	"sync-user-identity-providers",
+	"user-status-rules",
+	"user-status-inactive-after",
+	"incremental-sync",
+	"incremental-sync-max-age",
+}
+
+// newCommandConnector loads the configuration of a connector subcommand from its inherited flags and
+// the environment, validates it and returns a connector. Flags defined by the subcommand itself are
+// not part of the configuration.
+func newCommandConnector(ctx context.Context, cmd *cobra.Command, cfg *config) (*connector.Connector, error) {
+	v := viper.New()
+	v.SetEnvPrefix("baton")
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+	if err := v.BindPFlags(cmd.InheritedFlags()); err != nil {
+		return nil, err
+	}
+	for _, key := range configKeys {
+		if err := v.BindEnv(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(ctx, cfg); err != nil {
+		return nil, err
+	}
+
+	return connector.New(ctx, cfg.AccountID, cfg.ApiToken, cfg.ApiKey, cfg.Email, connectorOptions(cfg)...)
+}
+
+// connectorOptions returns the optional connector settings of the configuration.
+func connectorOptions(cfg *config) []connector.Option {
+	return []connector.Option{
+		connector.WithServiceTokenDuration(cfg.ServiceTokenDuration),
+		connector.WithServiceTokenGroupID(cfg.ServiceTokenGroupID),
+		connector.WithServiceTokenExpiryWarning(cfg.ServiceTokenExpiryWarning),
+		connector.WithSeatReport(cfg.SeatReportInactiveAfter),
+		connector.WithSessionRevocation(cfg.RevokeSessionsOnRevoke),
+		connector.WithDeviceRevocation(cfg.RevokeDevicesOnOffboard),
+		connector.WithActiveSessions(cfg.SyncActiveSessions),
+		connector.WithDevices(cfg.SyncDevices),
+		connector.WithFailedLogins(cfg.SyncFailedLogins, cfg.FailedLoginThreshold),
+		connector.WithIDPGroups(cfg.SyncIDPGroups),
+		connector.WithUserIdentityProviders(cfg.SyncUserIdentityProviders),
+		connector.WithUserStatus(cfg.UserStatusRules, cfg.UserStatusInactiveAfter),
+		connector.WithIncrementalSync(cfg.IncrementalSync, cfg.IncrementalSyncMaxAge),
+	}
 }
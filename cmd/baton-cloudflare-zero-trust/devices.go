@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newRevokeDevicesCmd returns the revoke-devices subcommand, which revokes every WARP device of a user.
+func newRevokeDevicesCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke-devices",
+		Short: "Revoke the WARP registration of every device of an Access user",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			user, err := cmd.Flags().GetString("user")
+			if err != nil {
+				return err
+			}
+			if user == "" {
+				return fmt.Errorf("user is required")
+			}
+
+			email, deviceIDs, err := cb.RevokeDevices(ctx, user)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("%d devices of %s revoked\n", len(deviceIDs), email)
+			for _, deviceID := range deviceIDs {
+				fmt.Println(deviceID)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("user", "", "Email or ID of the Access user whose devices to revoke")
+
+	return cmd
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// newExplainCmd returns the explain subcommand, which prints why a user can or can't access an application.
+func newExplainCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Explain why a user can or can't access an Access application",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// --email is shadowed by the user to explain, so the Cloudflare account email
+			// used with an API key can only come from $BATON_EMAIL here.
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			email, err := cmd.Flags().GetString("email")
+			if err != nil {
+				return err
+			}
+			app, err := cmd.Flags().GetString("app")
+			if err != nil {
+				return err
+			}
+			if email == "" || app == "" {
+				return fmt.Errorf("email and app are required")
+			}
+
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != "text" && output != "json" {
+				return fmt.Errorf("output must be text or json")
+			}
+
+			explanation, err := cb.ExplainAccess(ctx, email, app)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(explanation)
+			}
+
+			return explanation.WriteText(os.Stdout)
+		},
+	}
+
+	cmd.Flags().String("email", "", "Email of the user to explain access for")
+	cmd.Flags().String("app", "", "Name, ID or domain of the Access application")
+	cmd.Flags().String("output", "text", "The output format: text, json")
+
+	return cmd
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 
 	"github.com/conductorone/baton-sdk/pkg/cli"
-	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/types"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
@@ -28,6 +27,12 @@ func main() {
 
 	cmd.Version = version
 	cmdFlags(cmd)
+	cmd.AddCommand(newExplainCmd(ctx, cfg))
+	cmd.AddCommand(newRefreshServiceTokenCmd(ctx, cfg))
+	cmd.AddCommand(newDisableAPITokenCmd(ctx, cfg))
+	cmd.AddCommand(newReclaimSeatsCmd(ctx, cfg))
+	cmd.AddCommand(newRevokeSessionsCmd(ctx, cfg))
+	cmd.AddCommand(newRevokeDevicesCmd(ctx, cfg))
 
 	err = cmd.Execute()
 	if err != nil {
@@ -39,13 +44,13 @@ func main() {
 func getConnector(ctx context.Context, cfg *config) (types.ConnectorServer, error) {
 	l := ctxzap.Extract(ctx)
 
-	cb, err := connector.New(ctx, cfg.AccountID, cfg.ApiToken, cfg.ApiKey, cfg.Email)
+	cb, err := connector.New(ctx, cfg.AccountID, cfg.ApiToken, cfg.ApiKey, cfg.Email, connectorOptions(cfg)...)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
 	}
 
-	c, err := connectorbuilder.NewConnector(ctx, cb)
+	c, err := connector.NewServer(ctx, cb)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
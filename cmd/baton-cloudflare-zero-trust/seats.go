@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/conductorone/baton-cloudflare-zero-trust/pkg/connector"
+)
+
+const defaultSeatInactiveAfter = 90 * 24 * time.Hour
+
+// newReclaimSeatsCmd returns the reclaim-seats subcommand, which prints the seats held by inactive
+// Access users and, with --apply, removes them.
+func newReclaimSeatsCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reclaim-seats",
+		Short: "Plan, and with --apply remove, the Zero Trust seats of inactive Access users",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			inactiveAfter, err := cmd.Flags().GetDuration("inactive-after")
+			if err != nil {
+				return err
+			}
+			if inactiveAfter <= 0 {
+				return fmt.Errorf("inactive-after must be positive")
+			}
+			apply, err := cmd.Flags().GetBool("apply")
+			if err != nil {
+				return err
+			}
+			auditLog, err := cmd.Flags().GetString("audit-log")
+			if err != nil {
+				return err
+			}
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			if output != "text" && output != "json" {
+				return fmt.Errorf("output must be text or json")
+			}
+
+			plan, err := cb.SeatReclamationPlan(ctx, inactiveAfter)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(plan)
+			} else {
+				err = connector.WriteSeatReclamationPlan(os.Stdout, plan, inactiveAfter)
+			}
+			if err != nil {
+				return err
+			}
+
+			if !apply || len(plan) == 0 {
+				return nil
+			}
+
+			f, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			err = cb.ReclaimSeats(ctx, plan, f)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "reclaimed the seats of %d users, audit log written to %s\n", len(plan), auditLog)
+			return nil
+		},
+	}
+
+	cmd.Flags().Duration("inactive-after", defaultSeatInactiveAfter, "How long a user must have been inactive for their seats to be reclaimed")
+	cmd.Flags().Bool("apply", false, "Remove the seats of the plan instead of only printing it")
+	cmd.Flags().String("audit-log", "seat-reclamation-audit.jsonl", "File the seat changes are appended to as JSON lines")
+	cmd.Flags().String("output", "text", "The output format of the plan: text, json")
+
+	return cmd
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newRefreshServiceTokenCmd returns the refresh-service-token subcommand, which extends the expiry
+// of an Access service token without changing its secret.
+func newRefreshServiceTokenCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "refresh-service-token",
+		Short: "Extend the expiry of an Access service token without changing its secret",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			id, err := cmd.Flags().GetString("service-token-id")
+			if err != nil {
+				return err
+			}
+			if id == "" {
+				return fmt.Errorf("service-token-id is required")
+			}
+
+			expiresAt, err := cb.RefreshServiceToken(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("service token %s refreshed, expires at %s\n", id, expiresAt)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("service-token-id", "", "ID of the Access service token to refresh")
+
+	return cmd
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newRevokeSessionsCmd returns the revoke-sessions subcommand, which logs a user out of every Access application.
+func newRevokeSessionsCmd(ctx context.Context, cfg *config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "revoke-sessions",
+		Short: "Log an Access user out of every Access application",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cb, err := newCommandConnector(ctx, cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			user, err := cmd.Flags().GetString("user")
+			if err != nil {
+				return err
+			}
+			if user == "" {
+				return fmt.Errorf("user is required")
+			}
+
+			email, err := cb.RevokeSessions(ctx, user)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("sessions of %s revoked\n", email)
+			return nil
+		},
+	}
+
+	cmd.Flags().String("user", "", "Email or ID of the Access user to log out")
+
+	return cmd
+}
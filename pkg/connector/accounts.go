@@ -0,0 +1,86 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+type accountBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (a *accountBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return a.resourceType
+}
+
+// newAccountResource creates a new connector resource for the Cloudflare account, with its zones as children.
+func newAccountResource(account cloudflare.Account) (*v2.Resource, error) {
+	return rs.NewResource(
+		account.Name,
+		accountResourceType,
+		account.ID,
+		rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: zoneResourceType.Id}),
+	)
+}
+
+// List returns the account the connector is configured for.
+func (a *accountBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	account, _, err := a.client.Account(ctx, a.accountId)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to get account")
+	}
+
+	resource, err := newAccountResource(account)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to create account resource")
+	}
+
+	return []*v2.Resource{resource}, "", nil, nil
+}
+
+// Entitlements returns a permission entitlement for every API token permission group that applies to
+// the account or to all of its zones.
+func (a *accountBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	rv, err := permissionGroupEntitlements(ctx, a.client, resource, apiTokenAccountScope, apiTokenZoneScope)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grants returns the permission groups that API token policies allow on the whole account.
+func (a *accountBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return apiTokenPermissionGrants(ctx, a.client, resource, pToken, func(resources map[string]interface{}) bool {
+		return apiTokenCoversAccount(resources, a.accountId)
+	})
+}
+
+// Grant isn't supported: API token permissions are managed by the token owner.
+func (a *accountBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	return nil, fmt.Errorf("baton-cloudflare-zero-trust: api token permissions can't be granted by the connector")
+}
+
+// Revoke removes the permission on the account from the policies of the API token holding it.
+func (a *accountBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	resourceKey := apiTokenAccountScope + "." + grant.Entitlement.Resource.Id.Resource
+	return revokeAPITokenPermission(ctx, a.client, grant, resourceKey, func(resources map[string]interface{}) bool {
+		return apiTokenCoversAccount(resources, a.accountId)
+	})
+}
+
+func newAccountBuilder(client *cloudflare.API, accountId string) *accountBuilder {
+	return &accountBuilder{
+		resourceType: accountResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}
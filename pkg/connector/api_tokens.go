@@ -0,0 +1,536 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// Scopes of the resources and permission groups of API token policies, and API token statuses and policy effects.
+const (
+	apiTokenAccountScope = "com.cloudflare.api.account"
+	apiTokenZoneScope    = "com.cloudflare.api.account.zone"
+
+	apiTokenStatusActive   = "active"
+	apiTokenStatusDisabled = "disabled"
+	apiTokenEffectDeny     = "deny"
+)
+
+// apiToken is a user API token. cloudflare-go doesn't decode the last time a token was used,
+// so tokens are listed through the raw API.
+type apiToken struct {
+	cloudflare.APIToken
+	LastUsedOn *time.Time `json:"last_used_on,omitempty"`
+}
+
+type apiTokenBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (a *apiTokenBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return a.resourceType
+}
+
+// listAPITokens returns a page of the API tokens of the user the connector authenticates as. Tokens of
+// other members and account-owned tokens aren't returned by the user endpoint.
+func listAPITokens(ctx context.Context, client *cloudflare.API, page int) ([]apiToken, *cloudflare.ResultInfo, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	res, err := client.Raw(ctx, http.MethodGet, fmt.Sprintf("/user/tokens?page=%d&per_page=%d", page, resourcePageSize), nil, nil)
+	if err != nil {
+		return nil, nil, wrapError(err, "failed to list api tokens")
+	}
+
+	var tokens []apiToken
+	if err := json.Unmarshal(res.Result, &tokens); err != nil {
+		return nil, nil, wrapError(err, "failed to decode api tokens")
+	}
+
+	info := res.ResultInfo
+	if info == nil {
+		info = &cloudflare.ResultInfo{Page: page, TotalPages: page}
+	}
+
+	return tokens, info, nil
+}
+
+// newAPITokenResource creates a new connector resource for a Cloudflare user API token.
+// API tokens are credentials, so they carry a user trait with a service account type.
+func newAPITokenResource(token apiToken) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"token_id":     token.ID,
+		"name":         token.Name,
+		"status":       token.Status,
+		"policy_count": len(token.Policies),
+	}
+	for field, value := range map[string]*time.Time{
+		"issued_on":    token.IssuedOn,
+		"modified_on":  token.ModifiedOn,
+		"not_before":   token.NotBefore,
+		"expires_on":   token.ExpiresOn,
+		"last_used_on": token.LastUsedOn,
+	} {
+		if value != nil {
+			profile[field] = value.Format(time.RFC3339)
+		}
+	}
+	if token.Condition != nil && token.Condition.RequestIP != nil {
+		profile["ip_allowed"] = stringsProfileValue(token.Condition.RequestIP.In)
+		profile["ip_denied"] = stringsProfileValue(token.Condition.RequestIP.NotIn)
+	}
+
+	userTraits := []rs.UserTraitOption{
+		rs.WithUserProfile(profile),
+		rs.WithAccountType(v2.UserTrait_ACCOUNT_TYPE_SERVICE),
+	}
+
+	if token.Status == apiTokenStatusActive {
+		userTraits = append(userTraits, rs.WithStatus(v2.UserTrait_Status_STATUS_ENABLED))
+	} else {
+		userTraits = append(userTraits, rs.WithDetailedStatus(v2.UserTrait_Status_STATUS_DISABLED, token.Status))
+	}
+
+	if token.IssuedOn != nil {
+		userTraits = append(userTraits, rs.WithCreatedAt(*token.IssuedOn))
+	}
+	if token.LastUsedOn != nil {
+		userTraits = append(userTraits, rs.WithLastLogin(*token.LastUsedOn))
+	}
+
+	ret, err := rs.NewUserResource(token.Name, apiTokenResourceType, token.ID, userTraits)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// List returns the API tokens of the user the connector authenticates as.
+func (a *apiTokenBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: a.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	tokens, info, err := listAPITokens(ctx, a.client, page)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(tokens))
+	for _, token := range tokens {
+		resource, err := newAPITokenResource(token)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create api token resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	if info.TotalPages <= info.Page {
+		return resources, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return resources, nextPage, nil, nil
+}
+
+// Entitlements always returns an empty slice for API tokens.
+func (a *apiTokenBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for API tokens. Their permissions are granted on account and zone resources.
+func (a *apiTokenBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Rotate rolls the API token and returns its new value, encrypted by Baton with the supplied credential
+// options before it leaves the connector. The previous value stops working immediately.
+func (a *apiTokenBuilder) Rotate(
+	ctx context.Context,
+	resourceId *v2.ResourceId,
+	credentialOptions *v2.CredentialOptions,
+) ([]*v2.PlaintextData, annotations.Annotations, error) {
+	value, err := a.client.RollAPIToken(ctx, resourceId.Resource)
+	if err != nil {
+		return nil, nil, wrapError(err, "failed to roll api token")
+	}
+
+	return []*v2.PlaintextData{
+		{
+			Name:        "api_token",
+			Description: "Cloudflare API token value",
+			Bytes:       []byte(value),
+		},
+	}, nil, nil
+}
+
+// Create isn't supported: API tokens are created by their owner along with their permission policies.
+func (a *apiTokenBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, fmt.Errorf("baton-cloudflare-zero-trust: api tokens can't be created by the connector")
+}
+
+// Delete deletes the API token.
+func (a *apiTokenBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	err := a.client.DeleteAPIToken(ctx, resourceId.Resource)
+	if err != nil {
+		return nil, wrapError(err, "failed to delete api token")
+	}
+
+	return nil, nil
+}
+
+// disableAPIToken sets the status of an API token to disabled. Disabled tokens keep their value and
+// permissions and can be enabled again from the dashboard.
+func disableAPIToken(ctx context.Context, client *cloudflare.API, tokenID string) error {
+	l := ctxzap.Extract(ctx)
+
+	token, err := client.GetAPIToken(ctx, tokenID)
+	if err != nil {
+		return wrapError(err, "failed to get api token")
+	}
+
+	if token.Status == apiTokenStatusDisabled {
+		return nil
+	}
+
+	token.Status = apiTokenStatusDisabled
+	_, err = client.UpdateAPIToken(ctx, tokenID, token)
+	if err != nil {
+		return wrapError(err, "failed to disable api token")
+	}
+
+	l.Info("baton-cloudflare-zero-trust: api token disabled", zap.String("token_id", tokenID))
+
+	return nil
+}
+
+// DisableAPIToken disables an API token without deleting it.
+func (d *Connector) DisableAPIToken(ctx context.Context, tokenID string) error {
+	return disableAPIToken(ctx, d.client, tokenID)
+}
+
+// revokeAPITokenPermission removes a permission group from the API token policies granting it on an
+// account or zone, leaving the other permissions of the token in place. resourceKey is the policy
+// resource of the account or zone. Policies that name the resource alongside others are split so the
+// other resources keep the permission, and the revoke fails when a policy only grants the permission
+// through a wildcard or a nested resource, which can't be narrowed without affecting other resources.
+func revokeAPITokenPermission(
+	ctx context.Context,
+	client *cloudflare.API,
+	g *v2.Grant,
+	resourceKey string,
+	covers func(resources map[string]interface{}) bool,
+) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if g.Principal.Id.ResourceType != apiTokenResourceType.Id {
+		l.Warn(
+			"baton-cloudflare-zero-trust: only api token permissions can be revoked",
+			zap.String("principal_type", g.Principal.Id.ResourceType),
+			zap.String("principal_id", g.Principal.Id.Resource),
+		)
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: only api token permissions can be revoked")
+	}
+
+	tokenID := g.Principal.Id.Resource
+	permissionGroupID := g.Entitlement.Slug
+	if permissionGroupID == "" {
+		permissionGroupID = g.Entitlement.Id[strings.LastIndex(g.Entitlement.Id, ":")+1:]
+	}
+
+	token, err := client.GetAPIToken(ctx, tokenID)
+	if err != nil {
+		return nil, wrapError(err, "failed to get api token")
+	}
+
+	policies, changed, err := removeAPITokenPermission(token.Policies, permissionGroupID, resourceKey, covers)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		l.Info(
+			"baton-cloudflare-zero-trust: api token doesn't hold the permission",
+			zap.String("token_id", tokenID),
+			zap.String("permission_group_id", permissionGroupID),
+		)
+		return nil, nil
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: api token %s has no other permission, delete or disable it instead", tokenID)
+	}
+
+	token.Policies = policies
+	_, err = client.UpdateAPIToken(ctx, tokenID, token)
+	if err != nil {
+		return nil, wrapError(err, "failed to update api token policies")
+	}
+
+	l.Info(
+		"baton-cloudflare-zero-trust: api token permission revoked",
+		zap.String("token_id", tokenID),
+		zap.String("permission_group_id", permissionGroupID),
+		zap.String("resource", resourceKey),
+	)
+
+	return nil, nil
+}
+
+// removeAPITokenPermission returns the policies of an API token without the allow policies granting a
+// permission group on resourceKey, and whether any policy changed. A policy naming other resources too is
+// split in two: one keeping every permission on the other resources, and one keeping the other permissions
+// on resourceKey. Policies left without permissions are dropped.
+func removeAPITokenPermission(
+	policies []cloudflare.APITokenPolicies,
+	permissionGroupID string,
+	resourceKey string,
+	covers func(resources map[string]interface{}) bool,
+) ([]cloudflare.APITokenPolicies, bool, error) {
+	rv := make([]cloudflare.APITokenPolicies, 0, len(policies))
+	changed := false
+	for _, policy := range policies {
+		if policy.Effect == apiTokenEffectDeny || !covers(policy.Resources) || !hasPermissionGroup(policy.PermissionGroups, permissionGroupID) {
+			rv = append(rv, policy)
+			continue
+		}
+
+		value, ok := policy.Resources[resourceKey]
+		if !ok {
+			return nil, false, fmt.Errorf(
+				"baton-cloudflare-zero-trust: api token policy %s grants the permission through a wildcard or nested resource, it can't be revoked on %s alone",
+				policy.ID,
+				resourceKey,
+			)
+		}
+		changed = true
+
+		others := make(map[string]interface{}, len(policy.Resources)-1)
+		for key, v := range policy.Resources {
+			if key != resourceKey {
+				others[key] = v
+			}
+		}
+		if len(others) > 0 {
+			kept := policy
+			kept.Resources = others
+			rv = append(rv, kept)
+		}
+
+		permissionGroups := withoutPermissionGroup(policy.PermissionGroups, permissionGroupID)
+		if len(permissionGroups) == 0 {
+			continue
+		}
+
+		narrowed := cloudflare.APITokenPolicies{
+			Effect:           policy.Effect,
+			Resources:        map[string]interface{}{resourceKey: value},
+			PermissionGroups: permissionGroups,
+		}
+		if len(others) == 0 {
+			narrowed.ID = policy.ID
+		}
+		rv = append(rv, narrowed)
+	}
+
+	return rv, changed, nil
+}
+
+func hasPermissionGroup(permissionGroups []cloudflare.APITokenPermissionGroups, id string) bool {
+	for _, permissionGroup := range permissionGroups {
+		if permissionGroup.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withoutPermissionGroup(permissionGroups []cloudflare.APITokenPermissionGroups, id string) []cloudflare.APITokenPermissionGroups {
+	rv := make([]cloudflare.APITokenPermissionGroups, 0, len(permissionGroups))
+	for _, permissionGroup := range permissionGroups {
+		if permissionGroup.ID != id {
+			rv = append(rv, permissionGroup)
+		}
+	}
+
+	return rv
+}
+
+// permissionGroupEntitlements returns a permission entitlement for every API token permission group
+// that applies to one of the given scopes.
+func permissionGroupEntitlements(ctx context.Context, client *cloudflare.API, resource *v2.Resource, scopes ...string) ([]*v2.Entitlement, error) {
+	permissionGroups, err := client.ListAPITokensPermissionGroups(ctx)
+	if err != nil {
+		return nil, wrapError(err, "failed to list api token permission groups")
+	}
+
+	var rv []*v2.Entitlement
+	for _, permissionGroup := range permissionGroups {
+		if !hasAnyScope(permissionGroup.Scopes, scopes) {
+			continue
+		}
+
+		options := []ent.EntitlementOption{
+			ent.WithGrantableTo(apiTokenResourceType),
+			ent.WithDisplayName(fmt.Sprintf("%s %s", resource.DisplayName, permissionGroup.Name)),
+			ent.WithDescription(fmt.Sprintf("%s on %s granted to API tokens", permissionGroup.Name, resource.DisplayName)),
+		}
+
+		rv = append(rv, ent.NewPermissionEntitlement(resource, permissionGroup.ID, options...))
+	}
+
+	return rv, nil
+}
+
+func hasAnyScope(scopes []string, wanted []string) bool {
+	for _, scope := range scopes {
+		for _, w := range wanted {
+			if scope == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// apiTokenPermissionGrants returns a grant for every permission group that the policies of a page of API
+// tokens allow on a resource, and that no deny policy of the same token takes away. covers reports
+// whether the resources of a policy include the resource.
+func apiTokenPermissionGrants(
+	ctx context.Context,
+	client *cloudflare.API,
+	resource *v2.Resource,
+	pToken *pagination.Token,
+	covers func(resources map[string]interface{}) bool,
+) ([]*v2.Grant, string, annotations.Annotations, error) {
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: resource.Id.ResourceType, Resource: resource.Id.Resource})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	tokens, info, err := listAPITokens(ctx, client, page)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, token := range tokens {
+		allowed := make(map[string]bool)
+		denied := make(map[string]bool)
+		policyIDs := make(map[string]string)
+		for _, policy := range token.Policies {
+			if !covers(policy.Resources) {
+				continue
+			}
+
+			for _, permissionGroup := range policy.PermissionGroups {
+				if policy.Effect == apiTokenEffectDeny {
+					denied[permissionGroup.ID] = true
+					continue
+				}
+
+				allowed[permissionGroup.ID] = true
+				if _, ok := policyIDs[permissionGroup.ID]; !ok {
+					policyIDs[permissionGroup.ID] = policy.ID
+				}
+			}
+		}
+
+		if len(allowed) == 0 {
+			continue
+		}
+
+		tokenID, err := rs.NewResourceID(apiTokenResourceType, token.ID)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create api token resource id")
+		}
+
+		for _, permissionGroupID := range sortedKeys(allowed) {
+			if denied[permissionGroupID] {
+				continue
+			}
+
+			rv = append(rv, grant.NewGrant(resource, permissionGroupID, tokenID, grant.WithGrantMetadata(map[string]interface{}{
+				"policy_id":    policyIDs[permissionGroupID],
+				"token_status": token.Status,
+			})))
+		}
+	}
+
+	if info.TotalPages <= info.Page {
+		return rv, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, nextPage, nil, nil
+}
+
+// apiTokenCoversAccount reports whether the resources of an API token policy include the whole account.
+// Accounts mapped to nested zone resources only cover those zones.
+func apiTokenCoversAccount(resources map[string]interface{}, accountID string) bool {
+	for _, key := range []string{apiTokenAccountScope + ".*", apiTokenAccountScope + "." + accountID} {
+		if value, ok := resources[key].(string); ok && value == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apiTokenCoversZone reports whether the resources of an API token policy include a zone, either
+// directly or through the zones of its account.
+func apiTokenCoversZone(resources map[string]interface{}, accountID string, zoneID string) bool {
+	if hasZoneResource(resources, zoneID) {
+		return true
+	}
+
+	for _, key := range []string{apiTokenAccountScope + ".*", apiTokenAccountScope + "." + accountID} {
+		if nested, ok := resources[key].(map[string]interface{}); ok && hasZoneResource(nested, zoneID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasZoneResource(resources map[string]interface{}, zoneID string) bool {
+	_, all := resources[apiTokenZoneScope+".*"]
+	_, zone := resources[apiTokenZoneScope+"."+zoneID]
+
+	return all || zone
+}
+
+func newAPITokenBuilder(client *cloudflare.API, accountId string) *apiTokenBuilder {
+	return &apiTokenBuilder{
+		resourceType: apiTokenResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}
@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestRemoveAPITokenPermission(t *testing.T) {
+	const (
+		zoneA = apiTokenZoneScope + ".a"
+		zoneB = apiTokenZoneScope + ".b"
+	)
+	read := cloudflare.APITokenPermissionGroups{ID: "read"}
+	edit := cloudflare.APITokenPermissionGroups{ID: "edit"}
+	coversA := func(resources map[string]interface{}) bool {
+		return apiTokenCoversZone(resources, "account", "a")
+	}
+
+	tests := []struct {
+		name        string
+		policies    []cloudflare.APITokenPolicies
+		want        []cloudflare.APITokenPolicies
+		wantChanged bool
+		wantErr     bool
+	}{
+		{
+			name: "drops the only permission",
+			policies: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+				{ID: "p2", Effect: "allow", Resources: map[string]interface{}{zoneB: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+			},
+			want: []cloudflare.APITokenPolicies{
+				{ID: "p2", Effect: "allow", Resources: map[string]interface{}{zoneB: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "keeps the other permissions",
+			policies: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read, edit}},
+			},
+			want: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read}},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "splits policies covering other resources",
+			policies: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{zoneA: "*", zoneB: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read, edit}},
+			},
+			want: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{zoneB: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read, edit}},
+				{Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read}},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "leaves deny policies and other permissions alone",
+			policies: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: apiTokenEffectDeny, Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+				{ID: "p2", Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read}},
+			},
+			want: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: apiTokenEffectDeny, Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+				{ID: "p2", Effect: "allow", Resources: map[string]interface{}{zoneA: "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{read}},
+			},
+		},
+		{
+			name: "fails on wildcards",
+			policies: []cloudflare.APITokenPolicies{
+				{ID: "p1", Effect: "allow", Resources: map[string]interface{}{apiTokenZoneScope + ".*": "*"}, PermissionGroups: []cloudflare.APITokenPermissionGroups{edit}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := removeAPITokenPermission(tt.policies, "edit", zoneA, coversA)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("removeAPITokenPermission() error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("removeAPITokenPermission() changed = %t, want %t", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("removeAPITokenPermission() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,514 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const accessEntitlement = "access"
+
+type applicationBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	// revokeSessions ends the Access sessions of users whose access is revoked.
+	revokeSessions bool
+	// sessions records the live sessions of users on the application when set.
+	sessions *sessionCache
+	caches   *syncCaches
+}
+
+func (a *applicationBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return a.resourceType
+}
+
+// newApplicationResource creates a new connector resource for a Cloudflare Access application.
+func newApplicationResource(app cloudflare.AccessApplication, policies []cloudflare.AccessPolicy, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	tags := make([]interface{}, 0, len(app.Tags))
+	for _, tag := range app.Tags {
+		tags = append(tags, tag)
+	}
+
+	profile := map[string]interface{}{
+		"application_id":   app.ID,
+		"application_name": app.Name,
+		"application_type": string(app.Type),
+		"domain":           app.Domain,
+		"aud":              app.AUD,
+		"tags":             tags,
+		"risk_flags":       stringsProfileValue(applicationRiskFlags(policies)),
+		"allowed_idps":     stringsProfileValue(app.AllowedIdps),
+	}
+
+	appTraitOptions := []rs.AppTraitOption{
+		rs.WithAppProfile(profile),
+	}
+
+	resourceOptions := []rs.ResourceOption{
+		rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: policyResourceType.Id}),
+	}
+	if parentResourceID != nil {
+		resourceOptions = append(resourceOptions, rs.WithParentResourceID(parentResourceID))
+	}
+
+	ret, err := rs.NewAppResource(
+		app.Name,
+		applicationResourceType,
+		app.ID,
+		appTraitOptions,
+		resourceOptions...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// newBookmarkResource creates a new connector resource for a Cloudflare Access bookmark application.
+// Bookmarks have no policies, so unlike other applications they have no policy children.
+func newBookmarkResource(bookmark cloudflare.AccessBookmark) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"application_id":   bookmark.ID,
+		"application_name": bookmark.Name,
+		"application_type": string(cloudflare.Bookmark),
+		"domain":           bookmark.Domain,
+	}
+
+	appTraitOptions := []rs.AppTraitOption{
+		rs.WithAppProfile(profile),
+	}
+
+	ret, err := rs.NewAppResource(
+		bookmark.Name,
+		applicationResourceType,
+		bookmark.ID,
+		appTraitOptions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// groupApplicationsByTag groups applications by tag, listing applications carrying several tags under
+// each of them and untagged applications under the empty tag. Bookmarks are left out as they are listed
+// from the bookmarks endpoint.
+func groupApplicationsByTag(apps []cloudflare.AccessApplication) map[string][]cloudflare.AccessApplication {
+	rv := make(map[string][]cloudflare.AccessApplication)
+	for _, app := range apps {
+		if app.Type == cloudflare.Bookmark {
+			continue
+		}
+
+		if len(app.Tags) == 0 {
+			rv[""] = append(rv[""], app)
+			continue
+		}
+
+		seen := make(map[string]bool, len(app.Tags))
+		for _, tag := range app.Tags {
+			if seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			rv[tag] = append(rv[tag], app)
+		}
+	}
+
+	return rv
+}
+
+// List returns the Access applications of the account as resource objects. Tagged applications are
+// listed as children of every tag they carry, and bookmark applications are listed once all the others
+// have been. The applications are listed once per sync and grouped by tag.
+func (a *applicationBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: a.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if bag.ResourceID() == string(cloudflare.Bookmark) {
+		return a.listBookmarks(ctx, bag, page)
+	}
+
+	byTag, err := a.caches.applicationsByTag.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	tag := ""
+	if parentResourceID != nil && parentResourceID.ResourceType == tagResourceType.Id {
+		tag = parentResourceID.Resource
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	apps := byTag[tag]
+	start := (page - 1) * resourcePageSize
+	if start > len(apps) {
+		start = len(apps)
+	}
+	end := start + resourcePageSize
+	if end > len(apps) {
+		end = len(apps)
+	}
+
+	resources := make([]*v2.Resource, 0, end-start)
+	for _, app := range apps[start:end] {
+		policies, _, err := a.client.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.ListAccessPoliciesParams{
+			ApplicationID: app.ID,
+		})
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to list access policies")
+		}
+
+		resource, err := newApplicationResource(app, policies, parentResourceID)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create application resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	if end < len(apps) {
+		nextPage, err := getPageTokenFromPage(bag, page+1)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		return resources, nextPage, nil, nil
+	}
+
+	if parentResourceID != nil {
+		return resources, "", nil, nil
+	}
+
+	bag.Pop()
+	bag.Push(pagination.PageState{
+		ResourceTypeID: a.resourceType.Id,
+		ResourceID:     string(cloudflare.Bookmark),
+	})
+	nextPage, err := bag.Marshal()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return resources, nextPage, nil, nil
+}
+
+func (a *applicationBuilder) listBookmarks(ctx context.Context, bag *pagination.Bag, page int) ([]*v2.Resource, string, annotations.Annotations, error) {
+	bookmarks, info, err := a.client.AccessBookmarks(ctx, a.accountId, cloudflare.PaginationOptions{
+		Page:    page,
+		PerPage: resourcePageSize,
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access bookmarks")
+	}
+
+	resources := make([]*v2.Resource, 0, len(bookmarks))
+	for _, bookmark := range bookmarks {
+		resource, err := newBookmarkResource(bookmark)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create bookmark resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	if info.TotalPages <= info.Page {
+		return resources, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return resources, nextPage, nil, nil
+}
+
+// Entitlements returns the effective access entitlement of an application.
+func (a *applicationBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	options := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType, groupResourceType, serviceTokenResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s Application %s", resource.DisplayName, accessEntitlement)),
+		ent.WithDescription(fmt.Sprintf("Effective %s to %s Cloudflare application", accessEntitlement, resource.DisplayName)),
+	}
+
+	return []*v2.Entitlement{ent.NewPermissionEntitlement(resource, accessEntitlement, options...)}, "", nil, nil
+}
+
+// Grants evaluates the policies of the application against every Access user and service token and
+// returns a grant for each of them that can get in. Grants that depend on rules which can't be evaluated offline are
+// marked as conditional in the grant metadata. Applications with a policy that lets everyone in are
+// also granted to the synthetic all users group. When sessions are synced, the live sessions of each user on
+// the application are recorded in the grant metadata, including users the policies don't grant.
+func (a *applicationBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	trait, err := rs.GetAppTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Bookmarks only link to an external site and aren't protected by policies.
+	if appType, _ := rs.GetProfileStringValue(trait.Profile, "application_type"); appType == string(cloudflare.Bookmark) {
+		return nil, "", nil, nil
+	}
+
+	policies, _, err := a.client.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.ListAccessPoliciesParams{
+		ApplicationID: resource.Id.Resource,
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access policies")
+	}
+
+	if len(policies) == 0 {
+		return nil, "", nil, nil
+	}
+
+	evaluator, err := a.caches.evaluator.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	err = evaluator.prepare(ctx, policies)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users, err := a.caches.accessUsers.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	if groupContainsUser(riskEveryone, applicationRiskFlags(policies)) {
+		gr, err := allUsersGrant(resource, accessEntitlement)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create all users grant")
+		}
+		rv = append(rv, gr)
+	}
+
+	aud, _ := rs.GetProfileStringValue(trait.Profile, "aud")
+	for _, user := range users {
+		evaluation := evaluator.evaluate(policies, accessIdentity{Email: user.Email})
+		metadata := evaluation.metadata()
+
+		var sessions []accessUserSession
+		if a.sessions != nil {
+			userSessions, err := a.sessions.get(ctx, user.ID)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			sessions = applicationSessions(userSessions, resource.Id.Resource, aud)
+			addSessionUsage(metadata, sessions)
+		}
+
+		// Users with a live session the policies don't grant are reported too, so that unexpected
+		// usage shows up next to the effective access.
+		if !evaluation.Allowed && len(sessions) == 0 {
+			continue
+		}
+		metadata["granted_by_policy"] = evaluation.Allowed
+
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+
+		rv = append(rv, grant.NewGrant(resource, accessEntitlement, ur.Id, grant.WithGrantMetadata(metadata)))
+	}
+
+	tokenGrants, err := a.serviceTokenGrants(ctx, resource, evaluator, policies)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	rv = append(rv, tokenGrants...)
+
+	return rv, "", nil, nil
+}
+
+// serviceTokenGrants evaluates the policies of the application against every service token that hasn't
+// expired, and returns a grant for each of them that can get in.
+func (a *applicationBuilder) serviceTokenGrants(
+	ctx context.Context,
+	resource *v2.Resource,
+	evaluator *policyEvaluator,
+	policies []cloudflare.AccessPolicy,
+) ([]*v2.Grant, error) {
+	tokens, err := a.caches.serviceTokens.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []*v2.Grant
+	now := time.Now()
+	for _, token := range tokens {
+		if expiryStatus, _ := serviceTokenExpiry(token, 0, now); expiryStatus == serviceTokenExpired {
+			continue
+		}
+
+		evaluation := evaluator.evaluate(policies, accessIdentity{ServiceTokenID: token.ID})
+		if !evaluation.Allowed {
+			continue
+		}
+
+		tokenID, err := rs.NewResourceID(serviceTokenResourceType, token.ID)
+		if err != nil {
+			return nil, wrapError(err, "failed to create service token resource id")
+		}
+
+		rv = append(rv, grant.NewGrant(resource, accessEntitlement, tokenID, grant.WithGrantMetadata(evaluation.metadata())))
+	}
+
+	return rv, nil
+}
+
+// Grant isn't supported: application access is the outcome of its policies, so it is granted through
+// group membership.
+func (a *applicationBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	return nil, fmt.Errorf("baton-cloudflare-zero-trust: application access can't be granted directly, grant membership of a group instead")
+}
+
+// Revoke removes the email rules of the user from the include rules of the allow policies of the
+// application. The policies are evaluated without those rules first, and the revoke fails without
+// changing anything when the user would still get in through other rules, such as groups or email
+// domains. Policies left without include rules would admit nobody, so they are deleted instead of
+// being updated.
+func (a *applicationBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	principal := grant.Principal
+	appID := grant.Entitlement.Resource.Id.Resource
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-cloudflare-zero-trust: only users can have application access revoked",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: only users can have application access revoked")
+	}
+
+	email, err := getEmailFromUserTrait(principal)
+	if err != nil {
+		return nil, wrapError(err, "unable to get email from user trait")
+	}
+
+	policies, _, err := a.client.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.ListAccessPoliciesParams{
+		ApplicationID: appID,
+	})
+	if err != nil {
+		return nil, wrapError(err, "failed to list access policies")
+	}
+
+	revoked := make([]cloudflare.AccessPolicy, 0, len(policies))
+	var changed []int
+	for i, policy := range policies {
+		if policy.Decision != policyDecisionDeny {
+			include, removed := removeEmailRules(policy.Include, email)
+			if removed > 0 {
+				policy.Include = include
+				changed = append(changed, i)
+			}
+		}
+		revoked = append(revoked, policy)
+	}
+
+	evaluator, err := newPolicyEvaluator(ctx, a.client, a.accountId, revoked)
+	if err != nil {
+		return nil, err
+	}
+	if evaluation := evaluator.evaluate(revoked, accessIdentity{Email: email}); evaluation.Allowed {
+		return nil, fmt.Errorf(
+			"baton-cloudflare-zero-trust: %s would still have access to application %s through policy %s (conditional: %t), revoke it there instead",
+			email,
+			appID,
+			evaluation.PolicyID,
+			evaluation.Conditional,
+		)
+	}
+
+	for _, i := range changed {
+		err = a.updatePolicyInclude(ctx, appID, revoked[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if a.revokeSessions {
+		err = revokeAccessUserSessions(ctx, a.client, a.accountId, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// updatePolicyInclude saves the include rules of a policy, or deletes the policy when it has none left.
+func (a *applicationBuilder) updatePolicyInclude(ctx context.Context, appID string, policy cloudflare.AccessPolicy) error {
+	l := ctxzap.Extract(ctx)
+
+	if len(policy.Include) == 0 {
+		err := a.client.DeleteAccessPolicy(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.DeleteAccessPolicyParams{
+			ApplicationID: appID,
+			PolicyID:      policy.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("baton-cloudflare-zero-trust: failed to delete access policy left without include rules: %w", err)
+		}
+
+		l.Info(
+			"baton-cloudflare-zero-trust: deleted access policy left without include rules",
+			zap.String("application_id", appID),
+			zap.String("policy_id", policy.ID),
+		)
+		return nil
+	}
+
+	_, err := a.client.UpdateAccessPolicy(ctx, cloudflare.AccountIdentifier(a.accountId), cloudflare.UpdateAccessPolicyParams{
+		ApplicationID:                appID,
+		PolicyID:                     policy.ID,
+		Precedence:                   policy.Precedence,
+		Decision:                     policy.Decision,
+		Name:                         policy.Name,
+		IsolationRequired:            policy.IsolationRequired,
+		SessionDuration:              policy.SessionDuration,
+		PurposeJustificationRequired: policy.PurposeJustificationRequired,
+		PurposeJustificationPrompt:   policy.PurposeJustificationPrompt,
+		ApprovalRequired:             policy.ApprovalRequired,
+		ApprovalGroups:               policy.ApprovalGroups,
+		Include:                      policy.Include,
+		Exclude:                      policy.Exclude,
+		Require:                      policy.Require,
+	})
+	if err != nil {
+		return fmt.Errorf("baton-cloudflare-zero-trust: failed to remove user from access policy: %w", err)
+	}
+
+	return nil
+}
+
+func newApplicationBuilder(client *cloudflare.API, accountId string, revokeSessions bool, sessions *sessionCache, caches *syncCaches) *applicationBuilder {
+	return &applicationBuilder{
+		resourceType:   applicationResourceType,
+		client:         client,
+		accountId:      accountId,
+		revokeSessions: revokeSessions,
+		sessions:       sessions,
+		caches:         caches,
+	}
+}
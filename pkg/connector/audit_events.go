@@ -0,0 +1,444 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Kinds of audit log resources translated into events.
+const (
+	auditResourceAccessGroup   = "access_group"
+	auditResourceAccessPolicy  = "access_policy"
+	auditResourceAccountMember = "account_member"
+	auditResourceAPIToken      = "api_token"
+)
+
+// auditResourceTypes maps the resource types of the account audit log to the kinds of resources they record
+// changes of. Types are matched exactly, apart from their case, so records of other resources that happen
+// to share words with these, such as Gateway lists or service token rotations, aren't misread.
+var auditResourceTypes = map[string]string{
+	"access_group":         auditResourceAccessGroup,
+	"access_policy":        auditResourceAccessPolicy,
+	"access_app_policy":    auditResourceAccessPolicy,
+	"member":               auditResourceAccountMember,
+	"account_member":       auditResourceAccountMember,
+	"api_token":            auditResourceAPIToken,
+	"user_token":           auditResourceAPIToken,
+	"access_service_token": auditResourceServiceToken,
+	"service_token":        auditResourceServiceToken,
+	"teams_list":           auditResourceTeamsList,
+}
+
+// auditChangeActions are the action types of the account audit log that change a resource. Other actions,
+// such as logins or reads, are ignored.
+var auditChangeActions = map[string]bool{
+	"create": true,
+	"add":    true,
+	"update": true,
+	"delete": true,
+	"remove": true,
+	"roll":   true,
+	"rotate": true,
+}
+
+// auditResourceKind returns the kind of resource of a successful audit log record, or an empty string when
+// the record failed or is about a resource the connector doesn't track. Any action counts, so the change
+// tracker never misses a change made through an action it doesn't know.
+func auditResourceKind(record cloudflare.AuditLog) string {
+	if !record.Action.Result {
+		return ""
+	}
+
+	return auditResourceTypes[strings.ToLower(record.Resource.Type)]
+}
+
+// auditEventKind returns the kind of resource of an audit log record translated into events: a change
+// action of a tracked resource. Service tokens and lists only change the grants of other resources, so
+// they have no events of their own.
+func auditEventKind(record cloudflare.AuditLog) string {
+	if !auditChangeActions[strings.ToLower(record.Action.Type)] {
+		return ""
+	}
+
+	switch kind := auditResourceKind(record); kind {
+	case auditResourceServiceToken, auditResourceTeamsList:
+		return ""
+	default:
+		return kind
+	}
+}
+
+// auditEventContext resolves the principals of audit log changes.
+type auditEventContext struct {
+	users   map[string]cloudflare.AccessUser
+	members map[string]cloudflare.AccountMember
+}
+
+// listAuditEvents returns the events of a page of the account audit log and advances the cursor past it.
+func (d *Connector) listAuditEvents(ctx context.Context, cursor *logCursor, pageSize int) ([]*v2.Event, bool, error) {
+	l := ctxzap.Extract(ctx)
+
+	filter := cloudflare.AuditLogFilter{
+		Direction: "asc",
+		PerPage:   pageSize,
+	}
+	if !cursor.Since.IsZero() {
+		filter.Since = cursor.Since.Format(time.RFC3339)
+	}
+
+	res, err := d.client.GetOrganizationAuditLogs(ctx, d.accountId, filter)
+	if err != nil {
+		return nil, false, wrapError(err, "failed to list audit logs")
+	}
+
+	var (
+		records []cloudflare.AuditLog
+		fresh   int
+	)
+	for _, record := range res.Result {
+		if cursor.seen(record.When, record.ID) {
+			continue
+		}
+		cursor.advance(record.When, record.ID)
+		fresh++
+
+		if auditEventKind(record) != "" {
+			records = append(records, record)
+		}
+	}
+
+	hasMore := len(res.Result) >= pageSize
+	if hasMore && fresh == 0 {
+		// A whole page of records created within the same second was already returned, skip the second.
+		l.Warn(
+			"baton-cloudflare-zero-trust: audit log page holds no new records, skipping a second",
+			zap.Time("since", cursor.Since),
+		)
+		cursor.Since = cursor.Since.Add(time.Second)
+		cursor.Seen = nil
+	}
+
+	if len(records) == 0 {
+		return nil, hasMore, nil
+	}
+
+	ec, err := d.newAuditEventContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var rv []*v2.Event
+	for _, record := range records {
+		events, err := ec.events(ctx, record)
+		if err != nil {
+			return nil, false, err
+		}
+		rv = append(rv, events...)
+	}
+
+	return rv, hasMore, nil
+}
+
+// newAuditEventContext returns the principals of audit log changes, listed once per sync.
+func (d *Connector) newAuditEventContext(ctx context.Context) (*auditEventContext, error) {
+	users, err := d.caches.accessUsersByEmail.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := d.caches.accountMembers.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditEventContext{users: users, members: members}, nil
+}
+
+// events translates an audit log record into grant and revoke events for the membership changes it
+// records, and into a change event of the resource by the actor. The Baton event feed has no resource
+// change event, so changes are reported as usage events of the resource by the actor.
+func (e *auditEventContext) events(ctx context.Context, record cloudflare.AuditLog) ([]*v2.Event, error) {
+	details, err := auditDetails(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []*v2.Event
+	switch auditEventKind(record) {
+	case auditResourceAccessGroup:
+		groupID, err := rs.NewResourceID(groupResourceType, record.Resource.ID)
+		if err != nil {
+			return nil, err
+		}
+		entitlement := ent.NewAssignmentEntitlement(&v2.Resource{Id: groupID}, memberRole)
+
+		added, removed := diffStrings(auditGroupEmails(record.OldValueJSON), auditGroupEmails(record.NewValueJSON))
+		for _, email := range added {
+			if user, ok := e.users[email]; ok {
+				rv = append(rv, newGrantEvent(record, entitlement, user.ID, details))
+			}
+		}
+		for _, email := range removed {
+			if user, ok := e.users[email]; ok {
+				rv = append(rv, newRevokeEvent(record, entitlement, user.ID, details))
+			}
+		}
+	case auditResourceAccountMember:
+		userID := auditMemberUserID(record, e.members)
+		if userID == "" {
+			break
+		}
+
+		oldRoles, newRoles := auditMemberRoles(record.OldValueJSON), auditMemberRoles(record.NewValueJSON)
+		added, removed := diffStrings(mapKeys(oldRoles), mapKeys(newRoles))
+		for _, roleID := range added {
+			entitlement, err := roleEntitlement(ctx, record, roleID, newRoles[roleID])
+			if err != nil {
+				return nil, err
+			}
+			if entitlement != nil {
+				rv = append(rv, newGrantEvent(record, entitlement, userID, details))
+			}
+		}
+		for _, roleID := range removed {
+			entitlement, err := roleEntitlement(ctx, record, roleID, oldRoles[roleID])
+			if err != nil {
+				return nil, err
+			}
+			if entitlement != nil {
+				rv = append(rv, newRevokeEvent(record, entitlement, userID, details))
+			}
+		}
+	}
+
+	change, err := newChangeEvent(record, details)
+	if err != nil {
+		return nil, err
+	}
+	if change != nil {
+		rv = append(rv, change)
+	}
+
+	return rv, nil
+}
+
+// auditDetails returns the annotation describing the actor and the old and new values of a change.
+func auditDetails(record cloudflare.AuditLog) (*structpb.Struct, error) {
+	return structpb.NewStruct(map[string]interface{}{
+		"audit_log_id":  record.ID,
+		"action":        record.Action.Type,
+		"resource_type": record.Resource.Type,
+		"resource_id":   record.Resource.ID,
+		"actor_id":      record.Actor.ID,
+		"actor_email":   record.Actor.Email,
+		"actor_ip":      record.Actor.IP,
+		"actor_type":    record.Actor.Type,
+		"old_value":     auditValue(record.OldValue, record.OldValueJSON),
+		"new_value":     auditValue(record.NewValue, record.NewValueJSON),
+	})
+}
+
+func auditValue(value string, valueJSON map[string]interface{}) string {
+	if len(valueJSON) == 0 {
+		return value
+	}
+
+	data, err := json.Marshal(valueJSON)
+	if err != nil {
+		return value
+	}
+
+	return string(data)
+}
+
+// auditGroupEmails returns the normalized emails of the email include rules of an Access group value.
+func auditGroupEmails(value map[string]interface{}) []string {
+	include, _ := value["include"].([]interface{})
+
+	var rv []string
+	for _, email := range getAccessIncludeEmails(include) {
+		rv = append(rv, normalizeEmail(email))
+	}
+
+	return rv
+}
+
+// auditMemberRoles returns the names of the roles of an account member value by role ID.
+func auditMemberRoles(value map[string]interface{}) map[string]string {
+	roles, _ := value["roles"].([]interface{})
+
+	rv := make(map[string]string, len(roles))
+	for _, role := range roles {
+		rm, ok := role.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := rm["id"].(string)
+		name, _ := rm["name"].(string)
+		if id != "" {
+			rv[id] = name
+		}
+	}
+
+	return rv
+}
+
+// auditMemberUserID returns the user ID of the account member a record is about.
+func auditMemberUserID(record cloudflare.AuditLog, members map[string]cloudflare.AccountMember) string {
+	for _, value := range []map[string]interface{}{record.NewValueJSON, record.OldValueJSON} {
+		if user, ok := value["user"].(map[string]interface{}); ok {
+			if id, ok := user["id"].(string); ok && id != "" {
+				return id
+			}
+		}
+	}
+
+	if member, ok := members[record.Resource.ID]; ok {
+		return member.User.ID
+	}
+
+	return ""
+}
+
+// roleEntitlement returns the entitlement of a role, or nil when the record doesn't name the role: role
+// entitlements are keyed by name, so the change is logged and skipped rather than failing the feed.
+func roleEntitlement(ctx context.Context, record cloudflare.AuditLog, roleID string, roleName string) (*v2.Entitlement, error) {
+	if roleName == "" {
+		ctxzap.Extract(ctx).Warn(
+			"baton-cloudflare-zero-trust: audit log record has a role without a name, skipping its change",
+			zap.String("audit_log_id", record.ID),
+			zap.String("role_id", roleID),
+		)
+		return nil, nil
+	}
+
+	id, err := rs.NewResourceID(roleResourceType, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ent.NewAssignmentEntitlement(&v2.Resource{Id: id}, roleName), nil
+}
+
+func newGrantEvent(record cloudflare.AuditLog, entitlement *v2.Entitlement, userID string, details *structpb.Struct) *v2.Event {
+	principal := &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}
+
+	return &v2.Event{
+		Id:         fmt.Sprintf("%s:grant:%s:%s", record.ID, entitlement.Id, userID),
+		OccurredAt: timestamppb.New(record.When),
+		Event: &v2.Event_GrantEvent{
+			GrantEvent: &v2.GrantEvent{
+				Grant: grant.NewGrant(entitlement.Resource, entitlement.Slug, principal),
+			},
+		},
+		Annotations: annotations.New(details),
+	}
+}
+
+func newRevokeEvent(record cloudflare.AuditLog, entitlement *v2.Entitlement, userID string, details *structpb.Struct) *v2.Event {
+	return &v2.Event{
+		Id:         fmt.Sprintf("%s:revoke:%s:%s", record.ID, entitlement.Id, userID),
+		OccurredAt: timestamppb.New(record.When),
+		Event: &v2.Event_RevokeEvent{
+			RevokeEvent: &v2.RevokeEvent{
+				Entitlement: entitlement,
+				Principal:   &v2.Resource{Id: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}},
+			},
+		},
+		Annotations: annotations.New(details),
+	}
+}
+
+// newChangeEvent returns the change of the resource of a record by its actor, or nil when the actor is unknown.
+func newChangeEvent(record cloudflare.AuditLog, details *structpb.Struct) (*v2.Event, error) {
+	if record.Actor.ID == "" {
+		return nil, nil
+	}
+
+	var targetType *v2.ResourceType
+	switch auditEventKind(record) {
+	case auditResourceAccessGroup:
+		targetType = groupResourceType
+	case auditResourceAccessPolicy:
+		targetType = policyResourceType
+	case auditResourceAccountMember:
+		targetType = memberResourceType
+	case auditResourceAPIToken:
+		targetType = apiTokenResourceType
+	default:
+		return nil, nil
+	}
+
+	actorType := userResourceType
+	if record.Actor.Type == "token" {
+		actorType = apiTokenResourceType
+	}
+
+	return &v2.Event{
+		Id:         fmt.Sprintf("%s:change", record.ID),
+		OccurredAt: timestamppb.New(record.When),
+		Event: &v2.Event_UsageEvent{
+			UsageEvent: &v2.UsageEvent{
+				TargetResource: &v2.Resource{Id: &v2.ResourceId{ResourceType: targetType.Id, Resource: record.Resource.ID}},
+				ActorResource: &v2.Resource{
+					Id:          &v2.ResourceId{ResourceType: actorType.Id, Resource: record.Actor.ID},
+					DisplayName: record.Actor.Email,
+				},
+			},
+		},
+		Annotations: annotations.New(details),
+	}, nil
+}
+
+// diffStrings returns the values only in after and the values only in before.
+func diffStrings(before, after []string) ([]string, []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, v := range before {
+		inBefore[v] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, v := range after {
+		inAfter[v] = true
+	}
+
+	var added, removed []string
+	for _, v := range after {
+		if !inBefore[v] {
+			added = append(added, v)
+			inBefore[v] = true
+		}
+	}
+	for _, v := range before {
+		if !inAfter[v] {
+			removed = append(removed, v)
+			inAfter[v] = true
+		}
+	}
+
+	return added, removed
+}
+
+func mapKeys(m map[string]string) []string {
+	rv := make([]string, 0, len(m))
+	for k := range m {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
@@ -0,0 +1,104 @@
+package connector
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// userCacheTTL is how long a per-user lookup is reused. A sync looks users up once for the user
+// resources and again for every resource granted to them.
+const userCacheTTL = 5 * time.Minute
+
+type userCacheEntry[T any] struct {
+	value     T
+	fetchedAt time.Time
+}
+
+// userCache keeps the result of a per-user lookup for userCacheTTL.
+type userCache[T any] struct {
+	fetch func(ctx context.Context, userID string) (T, error)
+
+	mu      sync.Mutex
+	entries map[string]userCacheEntry[T]
+}
+
+func newUserCache[T any](fetch func(ctx context.Context, userID string) (T, error)) *userCache[T] {
+	return &userCache[T]{
+		fetch:   fetch,
+		entries: make(map[string]userCacheEntry[T]),
+	}
+}
+
+func (c *userCache[T]) get(ctx context.Context, userID string) (T, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < userCacheTTL {
+		return entry.value, nil
+	}
+
+	value, err := c.fetch(ctx, userID)
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	c.entries[userID] = userCacheEntry[T]{value: value, fetchedAt: time.Now()}
+	c.mu.Unlock()
+
+	return value, nil
+}
+
+// syncCacheTTL is how long a per-sync listing is reused when no sync start resets it, e.g. between
+// pages of the event feed.
+const syncCacheTTL = 30 * time.Minute
+
+// syncCache keeps a listing of the account that resources of several types depend on, so it is
+// fetched once per sync instead of once per resource or page. It is reset when a sync starts.
+type syncCache[T any] struct {
+	fetch func(ctx context.Context) (T, error)
+
+	mu        sync.Mutex
+	value     T
+	fetchedAt time.Time
+}
+
+func newSyncCache[T any](fetch func(ctx context.Context) (T, error)) *syncCache[T] {
+	return &syncCache[T]{fetch: fetch}
+}
+
+func (c *syncCache[T]) get(ctx context.Context) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < syncCacheTTL {
+		return c.value, nil
+	}
+
+	value, err := c.fetch(ctx)
+	if err != nil {
+		return value, err
+	}
+	c.value = value
+	c.fetchedAt = time.Now()
+
+	return value, nil
+}
+
+// olderThan reports whether the listing was fetched longer than d ago.
+func (c *syncCache[T]) olderThan(d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Since(c.fetchedAt) > d
+}
+
+func (c *syncCache[T]) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero T
+	c.value = zero
+	c.fetchedAt = time.Time{}
+}
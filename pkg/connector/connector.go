@@ -2,26 +2,189 @@ package connector
 
 import (
 	"context"
+	"net/http"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"golang.org/x/time/rate"
 )
 
 type Connector struct {
 	client    *cloudflare.API
 	accountId string
+	// httpClient sends the requests the Cloudflare client doesn't support, throttled along with it by limits.
+	httpClient    *http.Client
+	limits        *rateLimitTransport
+	caches        *syncCaches
+	serviceTokens serviceTokenConfig
+	// seatReportInactiveAfter enables the inactive seat report of syncs when set.
+	seatReportInactiveAfter time.Duration
+	// revokeSessions ends the Access sessions of users whose group membership or application access is revoked.
+	revokeSessions bool
+	// revokeDevices revokes the WARP devices of users whose last seat is revoked.
+	revokeDevices bool
+	// syncDevices enables the sync of WARP devices and of the device counts of users.
+	syncDevices bool
+	// syncSessions enables the sync of active sessions, looked up through sessions.
+	syncSessions bool
+	sessions     *sessionCache
+	failedLogins failedLoginConfig
+	// userStatusRules and userStatusInactiveAfter configure the status rules of users, validated into userStatus.
+	userStatusRules         []string
+	userStatusInactiveAfter time.Duration
+	userStatus              userStatusConfig
+	// syncIDPGroups and syncUserIDPs enable the sync of identity provider groups and of the identity
+	// provider users last authenticated with, both looked up through identities.
+	syncIDPGroups bool
+	syncUserIDPs  bool
+	identities    *identityCache
+	// incrementalSync carries unchanged grants forward from the previous sync, looked up through changes.
+	incrementalSync   bool
+	incrementalMaxAge time.Duration
+	changes           *changeTracker
+}
+
+// Option configures optional behaviour of the connector.
+type Option func(*Connector)
+
+// WithServiceTokenDuration sets the duration of the service tokens created by the connector, e.g. 8760h.
+func WithServiceTokenDuration(duration string) Option {
+	return func(c *Connector) {
+		c.serviceTokens.duration = duration
+	}
+}
+
+// WithServiceTokenGroupID sets the Access group that service tokens created by the connector are added to.
+func WithServiceTokenGroupID(groupID string) Option {
+	return func(c *Connector) {
+		c.serviceTokens.groupID = groupID
+	}
+}
+
+// WithServiceTokenExpiryWarning sets how long before their expiry service tokens are reported as expiring.
+func WithServiceTokenExpiryWarning(warning time.Duration) Option {
+	return func(c *Connector) {
+		c.serviceTokens.expiryWarning = warning
+	}
+}
+
+// WithSeatReport enables the sync-time report of seats held by users inactive for longer than inactiveAfter.
+func WithSeatReport(inactiveAfter time.Duration) Option {
+	return func(c *Connector) {
+		c.seatReportInactiveAfter = inactiveAfter
+	}
+}
+
+// WithSessionRevocation ends the Access sessions of users whose group membership or application access
+// is revoked, instead of letting them last until they expire.
+func WithSessionRevocation(revokeSessions bool) Option {
+	return func(c *Connector) {
+		c.revokeSessions = revokeSessions
+	}
+}
+
+// WithDeviceRevocation revokes every WARP device of a user when revoking a seat leaves them without any,
+// which offboards them.
+func WithDeviceRevocation(revokeDevices bool) Option {
+	return func(c *Connector) {
+		c.revokeDevices = revokeDevices
+	}
+}
+
+// WithDevices syncs the WARP devices of the account and records the device counts of every user in their
+// profile. Listing devices requires the Zero Trust devices read permission.
+func WithDevices(syncDevices bool) Option {
+	return func(c *Connector) {
+		c.syncDevices = syncDevices
+	}
+}
+
+// WithActiveSessions enriches users with their active Access sessions and records the live sessions
+// of each application in its access grants. It costs a request per user and session.
+func WithActiveSessions(syncSessions bool) Option {
+	return func(c *Connector) {
+		c.syncSessions = syncSessions
+	}
+}
+
+// WithFailedLogins summarises the recent failed Access logins of every user in their profile and flags
+// users with at least threshold failures at risk. A threshold of 0 never flags users.
+func WithFailedLogins(syncFailedLogins bool, threshold int) Option {
+	return func(c *Connector) {
+		c.failedLogins = failedLoginConfig{enabled: syncFailedLogins, threshold: threshold}
+	}
+}
+
+// WithUserStatus sets the rules the status of users is computed with, from UserStatusRules, and the
+// inactivity period of the inactive rule. Users that no rule disables are enabled. DefaultUserStatusRules
+// are used when it isn't set.
+func WithUserStatus(rules []string, inactiveAfter time.Duration) Option {
+	return func(c *Connector) {
+		c.userStatusRules = rules
+		c.userStatusInactiveAfter = inactiveAfter
+	}
+}
+
+// WithIDPGroups syncs the identity provider groups reported in the last seen identity of every user, and
+// grants Access groups to the identity provider groups their rules include. It costs a request per user.
+func WithIDPGroups(syncIDPGroups bool) Option {
+	return func(c *Connector) {
+		c.syncIDPGroups = syncIDPGroups
+	}
+}
+
+// WithUserIdentityProviders attributes every user to the identity provider they last authenticated with,
+// read from their last seen identity. It costs a request per user.
+func WithUserIdentityProviders(syncUserIDPs bool) Option {
+	return func(c *Connector) {
+		c.syncUserIDPs = syncUserIDPs
+	}
+}
+
+// WithIncrementalSync carries the grants of Access groups, policies and roles forward from the previous
+// sync when the account audit log records no change that could affect them. Grants older than maxAge are
+// synced in full again.
+func WithIncrementalSync(incrementalSync bool, maxAge time.Duration) Option {
+	return func(c *Connector) {
+		c.incrementalSync = incrementalSync
+		c.incrementalMaxAge = maxAge
+	}
 }
 
 // ResourceSyncers returns a ResourceSyncer for each resource type that should be synced from the upstream service.
 func (d *Connector) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
-	return []connectorbuilder.ResourceSyncer{
-		newUserBuilder(d.client, d.accountId),
-		newGroupBuilder(d.client, d.accountId),
-		newRoleBuilder(d.client, d.accountId),
+	var userIdentities *identityCache
+	if d.syncUserIDPs {
+		userIdentities = d.identities
+	}
+
+	syncers := []connectorbuilder.ResourceSyncer{
+		newUserBuilder(d.client, d.accountId, d.sessions, d.failedLogins, d.userStatus, userIdentities, d.caches),
+		newGroupBuilder(d.client, d.accountId, d.revokeSessions, d.syncIDPGroups, d.changes, d.caches),
+		newRoleBuilder(d.client, d.accountId, d.httpClient, d.changes),
 		newMemberBuilder(d.client, d.accountId),
+		newApplicationBuilder(d.client, d.accountId, d.revokeSessions, d.sessions, d.caches),
+		newPolicyBuilder(d.client, d.accountId, d.changes, d.caches),
+		newTagBuilder(d.client, d.accountId),
+		newServiceTokenBuilder(d.client, d.accountId, d.serviceTokens),
+		newAPITokenBuilder(d.client, d.accountId),
+		newAccountBuilder(d.client, d.accountId),
+		newZoneBuilder(d.client, d.accountId),
+		newSeatBuilder(d.client, d.accountId, d.seatReportInactiveAfter, d.revokeDevices),
+		newPostureRuleBuilder(d.client, d.accountId),
+		newIdentityProviderBuilder(d.client, d.accountId, userIdentities, d.caches),
 	}
+	if d.syncDevices {
+		syncers = append(syncers, newDeviceBuilder(d.client, d.accountId))
+	}
+	if d.syncIDPGroups {
+		syncers = append(syncers, newIDPGroupBuilder(d.client, d.accountId, d.caches))
+	}
+
+	return syncers
 }
 
 // Metadata returns metadata about the connector.
@@ -44,25 +207,59 @@ func (d *Connector) Validate(ctx context.Context) (annotations.Annotations, erro
 }
 
 // New returns a new instance of the connector.
-func New(ctx context.Context, accountId, apiToken, apiKey, email string) (*Connector, error) {
+func New(ctx context.Context, accountId, apiToken, apiKey, email string, opts ...Option) (*Connector, error) {
 	var (
 		client *cloudflare.API
 		err    error
 	)
+
+	// Requests are throttled and retried by the transport instead of the Cloudflare client, which doesn't
+	// honour Retry-After and doesn't share its limit with the other requests of the connector.
+	limits := newRateLimitTransport(http.DefaultTransport)
+	httpClient := &http.Client{Transport: limits}
+	clientOpts := []cloudflare.Option{
+		cloudflare.HTTPClient(httpClient),
+		cloudflare.UsingRateLimit(float64(rate.Inf)),
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+	}
+
 	if apiKey != "" && email != "" {
-		client, err = cloudflare.New(apiKey, email)
+		client, err = cloudflare.New(apiKey, email, clientOpts...)
 	}
 
 	if apiToken != "" {
-		client, err = cloudflare.NewWithAPIToken(apiToken)
+		client, err = cloudflare.NewWithAPIToken(apiToken, clientOpts...)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Connector{
-		client:    client,
-		accountId: accountId,
-	}, nil
+	c := &Connector{
+		client:     client,
+		accountId:  accountId,
+		httpClient: httpClient,
+		limits:     limits,
+
+		userStatusRules: DefaultUserStatusRules,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.userStatus, err = newUserStatusConfig(c.userStatusRules, c.userStatusInactiveAfter)
+	if err != nil {
+		return nil, err
+	}
+	if c.syncSessions {
+		c.sessions = newSessionCache(client, accountId)
+	}
+	if c.syncIDPGroups || c.syncUserIDPs {
+		c.identities = newIdentityCache(client, accountId)
+	}
+	c.caches = newSyncCaches(client, accountId, c.identities, c.userStatus, c.syncDevices)
+	if c.incrementalSync && c.incrementalMaxAge > 0 {
+		c.changes = newChangeTracker(client, accountId, c.incrementalMaxAge)
+	}
+
+	return c, nil
 }
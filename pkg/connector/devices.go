@@ -0,0 +1,243 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const deviceOwnerEntitlement = "owner"
+
+type deviceBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (d *deviceBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return d.resourceType
+}
+
+// deviceOS returns the operating system of a device with its version, as reported by the WARP client.
+func deviceOS(device cloudflare.TeamsDeviceListItem) string {
+	var parts []string
+	for _, part := range []string{device.DeviceType, device.OSDistroName, device.OSVersion, device.OSVersionExtra} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// newDeviceResource creates a new connector resource for a WARP device.
+func newDeviceResource(device cloudflare.TeamsDeviceListItem) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"device_id":     device.ID,
+		"device_type":   device.DeviceType,
+		"os":            deviceOS(device),
+		"os_version":    device.OSVersion,
+		"model":         device.Model,
+		"manufacturer":  device.Manufacturer,
+		"serial_number": device.SerialNumber,
+		"ip":            device.IP,
+		"mac_address":   device.MacAddress,
+		"warp_version":  device.Version,
+		"created":       device.Created,
+		"last_seen":     device.LastSeen,
+		"revoked_at":    device.RevokedAt,
+		"revoked":       device.RevokedAt != "",
+		"owner_user_id": device.User.ID,
+		"owner_email":   device.User.Email,
+		"owner_name":    device.User.Name,
+	}
+
+	displayName := device.Name
+	if displayName == "" {
+		displayName = device.ID
+	}
+
+	return rs.NewAppResource(
+		displayName,
+		deviceResourceType,
+		device.ID,
+		[]rs.AppTraitOption{rs.WithAppProfile(profile)},
+	)
+}
+
+// List returns the WARP devices enrolled in the account.
+func (d *deviceBuilder) List(ctx context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	devices, err := d.client.ListTeamsDevices(ctx, d.accountId)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list devices")
+	}
+
+	resources := make([]*v2.Resource, 0, len(devices))
+	for _, device := range devices {
+		if device.Deleted {
+			continue
+		}
+
+		resource, err := newDeviceResource(device)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create device resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+func (d *deviceBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	options := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s Device %s", resource.DisplayName, deviceOwnerEntitlement)),
+		ent.WithDescription(fmt.Sprintf("%s of %s WARP device", deviceOwnerEntitlement, resource.DisplayName)),
+	}
+
+	return []*v2.Entitlement{ent.NewAssignmentEntitlement(resource, deviceOwnerEntitlement, options...)}, "", nil, nil
+}
+
+// Grants returns the owner grant of the device to the user it is enrolled for.
+func (d *deviceBuilder) Grants(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	trait, err := rs.GetAppTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	userID, ok := rs.GetProfileStringValue(trait.Profile, "owner_user_id")
+	if !ok || userID == "" {
+		return nil, "", nil, nil
+	}
+
+	ur, err := rs.NewResourceID(userResourceType, userID)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to create user resource id")
+	}
+
+	revoked, _ := getProfileBoolValue(trait.Profile, "revoked")
+
+	return []*v2.Grant{
+		grant.NewGrant(resource, deviceOwnerEntitlement, ur, grant.WithGrantMetadata(map[string]interface{}{
+			"revoked": revoked,
+		})),
+	}, "", nil, nil
+}
+
+func (d *deviceBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, fmt.Errorf("baton-cloudflare-zero-trust: devices are enrolled with the WARP client and can't be created by the connector")
+}
+
+// Delete revokes the WARP registration of the device. The device has to be enrolled again to connect.
+func (d *deviceBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	err := revokeDevices(ctx, d.client, d.accountId, []string{resourceId.Resource})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// revokeDevices revokes the WARP registration of the devices.
+func revokeDevices(ctx context.Context, client *cloudflare.API, accountId string, deviceIDs []string) error {
+	l := ctxzap.Extract(ctx)
+
+	_, err := client.RevokeTeamsDevices(ctx, accountId, deviceIDs)
+	if err != nil {
+		return wrapError(err, "failed to revoke devices")
+	}
+
+	l.Info("baton-cloudflare-zero-trust: devices revoked", zap.Strings("device_ids", deviceIDs))
+
+	return nil
+}
+
+// revokeUserDevices revokes every WARP device of a user that isn't revoked yet and returns their IDs.
+func revokeUserDevices(ctx context.Context, client *cloudflare.API, accountId string, userID string) ([]string, error) {
+	devices, err := listUserDevices(ctx, client, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	var deviceIDs []string
+	for _, device := range devices[userID] {
+		if device.RevokedAt == "" {
+			deviceIDs = append(deviceIDs, device.ID)
+		}
+	}
+	if len(deviceIDs) == 0 {
+		return nil, nil
+	}
+
+	err = revokeDevices(ctx, client, accountId, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return deviceIDs, nil
+}
+
+// RevokeDevices revokes every WARP device of an Access user, looked up by email or ID, and returns the
+// email of the user and the IDs of the revoked devices.
+func (d *Connector) RevokeDevices(ctx context.Context, user string) (string, []string, error) {
+	accessUser, err := findAccessUser(ctx, d.client, d.accountId, user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	deviceIDs, err := revokeUserDevices(ctx, d.client, d.accountId, accessUser.ID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return accessUser.Email, deviceIDs, nil
+}
+
+// deviceCounts returns the number of devices and of devices that aren't revoked.
+func deviceCounts(devices []cloudflare.TeamsDeviceListItem) (int, int) {
+	active := 0
+	for _, device := range devices {
+		if device.RevokedAt == "" {
+			active++
+		}
+	}
+
+	return len(devices), active
+}
+
+// listUserDevices returns the WARP devices of the account by the ID of the user they are enrolled for.
+func listUserDevices(ctx context.Context, client *cloudflare.API, accountId string) (map[string][]cloudflare.TeamsDeviceListItem, error) {
+	devices, err := client.ListTeamsDevices(ctx, accountId)
+	if err != nil {
+		return nil, wrapError(err, "failed to list devices")
+	}
+
+	rv := make(map[string][]cloudflare.TeamsDeviceListItem)
+	for _, device := range devices {
+		if device.Deleted || device.User.ID == "" {
+			continue
+		}
+		rv[device.User.ID] = append(rv[device.User.ID], device)
+	}
+
+	return rv, nil
+}
+
+func newDeviceBuilder(client *cloudflare.API, accountId string) *deviceBuilder {
+	return &deviceBuilder{
+		resourceType: deviceResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}
@@ -0,0 +1,250 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultEventPageSize = 100
+
+// eventCursor is the position of the event feed.
+type eventCursor struct {
+	Access *logCursor `json:"access,omitempty"`
+	Audit  *logCursor `json:"audit,omitempty"`
+}
+
+// logCursor is the position in a Cloudflare log. The logs can only be filtered by second, so the IDs of the
+// records already returned for the second of the position are kept to skip them on the next page.
+type logCursor struct {
+	Since time.Time `json:"since"`
+	Seen  []string  `json:"seen,omitempty"`
+}
+
+func parseEventCursor(cursor string, earliestEvent *timestamppb.Timestamp) (*eventCursor, error) {
+	rv := &eventCursor{}
+	if cursor != "" {
+		err := json.Unmarshal([]byte(cursor), rv)
+		if err != nil {
+			return nil, wrapError(err, "failed to parse event cursor")
+		}
+	}
+
+	if rv.Access == nil {
+		rv.Access = newLogCursor(earliestEvent)
+	}
+	if rv.Audit == nil {
+		rv.Audit = newLogCursor(earliestEvent)
+	}
+
+	return rv, nil
+}
+
+// newLogCursor returns the position of a log at the earliest event, or at its start.
+func newLogCursor(earliestEvent *timestamppb.Timestamp) *logCursor {
+	if earliestEvent == nil {
+		return &logCursor{}
+	}
+
+	return &logCursor{Since: earliestEvent.AsTime().Truncate(time.Second)}
+}
+
+func (c *eventCursor) marshal() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// seen reports whether the record created at t with the given ID was already returned.
+func (c *logCursor) seen(t time.Time, id string) bool {
+	if !t.Truncate(time.Second).Equal(c.Since) {
+		return false
+	}
+
+	for _, seenID := range c.Seen {
+		if seenID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// advance moves the position to a record created at t with the given ID.
+func (c *logCursor) advance(t time.Time, id string) {
+	t = t.Truncate(time.Second)
+	if !t.Equal(c.Since) {
+		c.Since = t
+		c.Seen = nil
+	}
+	c.Seen = append(c.Seen, id)
+}
+
+// ListEvents returns the events since the cursor, or since earliestEvent on the first page, from two feeds:
+// the Access logins, as usage events of the application by the user whose details say whether the login
+// was allowed or denied, and the changes of the account audit log.
+func (d *Connector) ListEvents(ctx context.Context, earliestEvent *timestamppb.Timestamp, pToken *pagination.StreamToken) ([]*v2.Event, *pagination.StreamState, annotations.Annotations, error) {
+	cursor, err := parseEventCursor(pToken.Cursor, earliestEvent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pageSize := pToken.Size
+	if pageSize <= 0 {
+		pageSize = defaultEventPageSize
+	}
+
+	events, accessHasMore, err := d.listAccessEvents(ctx, cursor.Access, pageSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	auditEvents, auditHasMore, err := d.listAuditEvents(ctx, cursor.Audit, pageSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	events = append(events, auditEvents...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.AsTime().Before(events[j].OccurredAt.AsTime())
+	})
+
+	nextCursor, err := cursor.marshal()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return events, &pagination.StreamState{Cursor: nextCursor, HasMore: accessHasMore || auditHasMore}, nil, nil
+}
+
+// Outcomes of Access logins, recorded in the details of their usage events.
+const (
+	accessLoginAllowed = "allowed"
+	accessLoginDenied  = "denied"
+)
+
+func accessLoginOutcome(record cloudflare.AccessAuditLogRecord) string {
+	if record.Allowed {
+		return accessLoginAllowed
+	}
+
+	return accessLoginDenied
+}
+
+// listAccessEvents returns the usage events of a page of the Access audit log and advances the cursor past it.
+func (d *Connector) listAccessEvents(ctx context.Context, cursor *logCursor, pageSize int) ([]*v2.Event, bool, error) {
+	l := ctxzap.Extract(ctx)
+
+	opts := cloudflare.AccessAuditLogFilterOptions{
+		Direction: "asc",
+		Limit:     pageSize,
+	}
+	if !cursor.Since.IsZero() {
+		since := cursor.Since
+		opts.Since = &since
+	}
+
+	records, err := d.client.AccessAuditLogs(ctx, d.accountId, opts)
+	if err != nil {
+		return nil, false, wrapError(err, "failed to list access audit logs")
+	}
+
+	emails := make([]string, 0, len(records))
+	for _, record := range records {
+		emails = append(emails, record.UserEmail)
+	}
+	users, err := d.caches.accessUsersIncluding(ctx, emails)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var (
+		rv    []*v2.Event
+		fresh int
+	)
+	for _, record := range records {
+		if record.CreatedAt == nil || cursor.seen(*record.CreatedAt, record.RayID) {
+			continue
+		}
+		cursor.advance(*record.CreatedAt, record.RayID)
+		fresh++
+
+		event, err := newAccessUsageEvent(record, users)
+		if err != nil {
+			return nil, false, err
+		}
+		if event != nil {
+			rv = append(rv, event)
+		}
+	}
+
+	hasMore := len(records) >= pageSize
+	if hasMore && fresh == 0 {
+		// A whole page of records created within the same second was already returned, skip the second.
+		l.Warn(
+			"baton-cloudflare-zero-trust: access audit log page holds no new records, skipping a second",
+			zap.Time("since", cursor.Since),
+		)
+		cursor.Since = cursor.Since.Add(time.Second)
+		cursor.Seen = nil
+	}
+
+	return rv, hasMore, nil
+}
+
+// newAccessUsageEvent returns the usage event of an Access login, or nil when the user or the application
+// isn't known. The Baton event feed has no event type for denied logins, so they are usage events too,
+// told apart by the outcome of their details.
+func newAccessUsageEvent(record cloudflare.AccessAuditLogRecord, users map[string]cloudflare.AccessUser) (*v2.Event, error) {
+	user, ok := users[normalizeEmail(record.UserEmail)]
+	if !ok || record.AppUID == "" {
+		return nil, nil
+	}
+
+	actor, err := newUserResource(user)
+	if err != nil {
+		return nil, wrapError(err, "failed to create user resource")
+	}
+
+	appID, err := rs.NewResourceID(applicationResourceType, record.AppUID)
+	if err != nil {
+		return nil, wrapError(err, "failed to create application resource id")
+	}
+
+	details, err := structpb.NewStruct(map[string]interface{}{
+		"ray_id":     record.RayID,
+		"ip_address": record.IPAddress,
+		"connection": record.Connection,
+		"action":     record.Action,
+		"allowed":    record.Allowed,
+		"outcome":    accessLoginOutcome(record),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &v2.Event{
+		Id:         record.RayID,
+		OccurredAt: timestamppb.New(*record.CreatedAt),
+		Event: &v2.Event_UsageEvent{
+			UsageEvent: &v2.UsageEvent{
+				TargetResource: &v2.Resource{Id: appID, DisplayName: record.AppDomain},
+				ActorResource:  actor,
+			},
+		},
+		Annotations: annotations.New(details),
+	}, nil
+}
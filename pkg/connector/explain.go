@@ -0,0 +1,111 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// AccessExplanation describes why an identity can or can't access an Access application.
+type AccessExplanation struct {
+	Email           string `json:"email"`
+	ApplicationID   string `json:"application_id"`
+	ApplicationName string `json:"application_name"`
+	policyEvaluation
+}
+
+// ExplainAccess evaluates the policies of an application, looked up by ID, name or domain, for the given email
+// and returns the full decision trace.
+func (d *Connector) ExplainAccess(ctx context.Context, email string, app string) (*AccessExplanation, error) {
+	application, err := d.findApplication(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, _, err := d.client.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(d.accountId), cloudflare.ListAccessPoliciesParams{
+		ApplicationID: application.ID,
+	})
+	if err != nil {
+		return nil, wrapError(err, "failed to list access policies")
+	}
+
+	evaluator, err := newPolicyEvaluator(ctx, d.client, d.accountId, policies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessExplanation{
+		Email:            email,
+		ApplicationID:    application.ID,
+		ApplicationName:  application.Name,
+		policyEvaluation: evaluator.evaluate(policies, accessIdentity{Email: email}),
+	}, nil
+}
+
+func (d *Connector) findApplication(ctx context.Context, app string) (*cloudflare.AccessApplication, error) {
+	apps, _, err := d.client.ListAccessApplications(ctx, cloudflare.AccountIdentifier(d.accountId), cloudflare.ListAccessApplicationsParams{})
+	if err != nil {
+		return nil, wrapError(err, "failed to list access applications")
+	}
+
+	for i := range apps {
+		if apps[i].ID == app || strings.EqualFold(apps[i].Name, app) || strings.EqualFold(apps[i].Domain, app) {
+			return &apps[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("cloudflare-zero-trust-connector: application %q not found", app)
+}
+
+// WriteText writes a human readable decision trace.
+func (e *AccessExplanation) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	verdict := "DENIED"
+	if e.Allowed {
+		verdict = "ALLOWED"
+	}
+	fmt.Fprintf(&b, "%s -> %s (%s): %s\n", e.Email, e.ApplicationName, e.ApplicationID, verdict)
+	if e.PolicyID != "" {
+		fmt.Fprintf(&b, "decided by policy %q (%s)\n", e.PolicyName, e.Decision)
+	} else {
+		b.WriteString("no policy matched\n")
+	}
+	if e.Conditional {
+		fmt.Fprintf(&b, "conditional on rules that can't be evaluated offline: %s\n", strings.Join(e.Conditions, ", "))
+	}
+
+	for _, policy := range e.Trace {
+		fmt.Fprintf(&b, "\npolicy %q (%s, precedence %d): %s\n", policy.PolicyName, policy.Decision, policy.Precedence, policy.Result)
+		writeRuleTraces(&b, "include", policy.Include)
+		writeRuleTraces(&b, "require", policy.Require)
+		writeRuleTraces(&b, "exclude", policy.Exclude)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeRuleTraces(b *strings.Builder, section string, traces []ruleTrace) {
+	if len(traces) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "  %s:\n", section)
+	writeNestedRuleTraces(b, traces, 2)
+}
+
+func writeNestedRuleTraces(b *strings.Builder, traces []ruleTrace, depth int) {
+	for _, trace := range traces {
+		indent := strings.Repeat("  ", depth)
+		if trace.Value != "" {
+			fmt.Fprintf(b, "%s- %s %q: %s\n", indent, trace.Rule, trace.Value, trace.Result)
+		} else {
+			fmt.Fprintf(b, "%s- %s: %s\n", indent, trace.Rule, trace.Result)
+		}
+		writeNestedRuleTraces(b, trace.Nested, depth+1)
+	}
+}
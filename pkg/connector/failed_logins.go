@@ -0,0 +1,59 @@
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// failedLoginConfig controls the failed login summary of users.
+type failedLoginConfig struct {
+	// enabled syncs the recent failed logins of every user.
+	enabled bool
+	// threshold is the failed login count from which a user is flagged at risk.
+	threshold int
+}
+
+// failedLoginSummary summarises the recent failed Access logins of a user.
+type failedLoginSummary struct {
+	count       int
+	lastFailure time.Time
+	apps        []string
+}
+
+// getFailedLoginSummary returns the summary of the recent failed Access logins of a user.
+func getFailedLoginSummary(ctx context.Context, client *cloudflare.API, accountId string, userID string) (*failedLoginSummary, error) {
+	failures, err := client.GetAccessUserFailedLogins(ctx, cloudflare.AccountIdentifier(accountId), userID)
+	if err != nil {
+		return nil, wrapError(err, "failed to get access user failed logins")
+	}
+
+	rv := &failedLoginSummary{count: len(failures)}
+	apps := make(map[string]bool)
+	for _, failure := range failures {
+		if failure.Metadata.AppName != "" {
+			apps[failure.Metadata.AppName] = true
+		}
+		if t, ok := parseAccessTime(failure.Metadata.Datetime); ok && t.After(rv.lastFailure) {
+			rv.lastFailure = t
+		}
+	}
+	rv.apps = sortedKeys(apps)
+
+	return rv, nil
+}
+
+// failedLoginProfile returns the user profile fields describing the failed logins of a user and
+// whether they reach the risk threshold.
+func failedLoginProfile(summary *failedLoginSummary, threshold int) (map[string]interface{}, bool) {
+	profile := map[string]interface{}{
+		"failed_login_count": summary.count,
+		"failed_login_apps":  stringsProfileValue(summary.apps),
+	}
+	if !summary.lastFailure.IsZero() {
+		profile["last_failed_login"] = summary.lastFailure.Format(time.RFC3339)
+	}
+
+	return profile, threshold > 0 && summary.count >= threshold
+}
@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -15,12 +16,25 @@ import (
 	"go.uber.org/zap"
 )
 
-const memberRole = "member"
+const (
+	memberRole = "member"
+
+	// allUsersGroupID identifies the synthetic group containing every Access user. Groups and
+	// applications open to everyone are granted to it.
+	allUsersGroupID = "all_users"
+)
 
 type groupBuilder struct {
 	resourceType *v2.ResourceType
 	client       *cloudflare.API
 	accountId    string
+	// revokeSessions ends the Access sessions of users whose membership is revoked.
+	revokeSessions bool
+	// syncIDPGroups grants groups to the identity provider groups their rules include.
+	syncIDPGroups bool
+	// changes carries memberships forward from the previous sync when incremental sync is enabled.
+	changes *changeTracker
+	caches  *syncCaches
 }
 
 func (g *groupBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
@@ -32,6 +46,9 @@ func newGroupResource(group *cloudflare.AccessGroup) (*v2.Resource, error) {
 	profile := map[string]interface{}{
 		"group_name": group.Name,
 		"group_id":   group.ID,
+		"risk_flags": stringsProfileValue(groupRiskFlags(group)),
+		// Membership is conditional on device compliance when the group references posture rules.
+		"posture_rule_ids": stringsProfileValue(postureRuleIDs(group.Include, group.Require, group.Exclude)),
 	}
 
 	groupTraitOptions := []rs.GroupTraitOption{
@@ -51,6 +68,43 @@ func newGroupResource(group *cloudflare.AccessGroup) (*v2.Resource, error) {
 	return ret, nil
 }
 
+// newAllUsersGroupResource creates the synthetic group containing every Access user.
+func newAllUsersGroupResource() (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"group_name": "All Users",
+		"group_id":   allUsersGroupID,
+		"synthetic":  true,
+	}
+
+	return rs.NewGroupResource(
+		"All Users",
+		groupResourceType,
+		allUsersGroupID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription("Every Access user. Groups and applications open to everyone are granted to this group."),
+	)
+}
+
+// allUsersGrant returns an expandable grant of the entitlement to the synthetic group containing every Access user.
+func allUsersGrant(resource *v2.Resource, entitlementName string) (*v2.Grant, error) {
+	allUsers, err := newAllUsersGroupResource()
+	if err != nil {
+		return nil, err
+	}
+
+	return grant.NewGrant(
+		resource,
+		entitlementName,
+		allUsers.Id,
+		grant.WithAnnotation(&v2.GrantExpandable{
+			EntitlementIds: []string{ent.NewEntitlementID(allUsers, memberRole)},
+		}),
+		grant.WithGrantMetadata(map[string]interface{}{
+			"risk_flags": stringsProfileValue([]string{riskEveryone}),
+		}),
+	), nil
+}
+
 // List returns all the access groups from the database as resource objects.
 func (g *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	groups, _, err := g.client.ListAccessGroups(ctx, cloudflare.AccountIdentifier(g.accountId), cloudflare.ListAccessGroupsParams{})
@@ -58,7 +112,13 @@ func (g *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId
 		return nil, "", nil, wrapError(err, "failed to list access groups")
 	}
 
-	resources := make([]*v2.Resource, 0, len(groups))
+	allUsers, err := newAllUsersGroupResource()
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to create group resource")
+	}
+
+	resources := make([]*v2.Resource, 0, len(groups)+1)
+	resources = append(resources, allUsers)
 	for _, group := range groups {
 		groupCopy := group
 		resource, err := newGroupResource(&groupCopy)
@@ -75,7 +135,7 @@ func (g *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId
 func (g *groupBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
 	var rv []*v2.Entitlement
 	options := []ent.EntitlementOption{
-		ent.WithGrantableTo(userResourceType),
+		ent.WithGrantableTo(userResourceType, groupResourceType, serviceTokenResourceType, idpGroupResourceType),
 		ent.WithDisplayName(fmt.Sprintf("%s Group %s", resource.DisplayName, memberRole)),
 		ent.WithDescription(fmt.Sprintf("%s of %s Cloudflare group", memberRole, resource.DisplayName)),
 	}
@@ -90,6 +150,18 @@ func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 		users []cloudflare.AccessUser
 		rv    []*v2.Grant
 	)
+	if resource.Id.Resource == allUsersGroupID {
+		return g.allUsersGrants(ctx, resource, pToken)
+	}
+
+	// Memberships follow the group rules, the emails of account members and the service tokens.
+	entitlementID := ent.NewEntitlementID(resource, memberRole)
+	since := g.changes.watermark(ctx)
+	annos, ok := g.changes.carryForward(ctx, resource, entitlementID, auditResourceAccessGroup, auditResourceAccountMember, auditResourceServiceToken)
+	if ok {
+		return nil, "", annos, nil
+	}
+
 	group, err := g.client.GetAccessGroup(ctx, cloudflare.AccountIdentifier(g.accountId), resource.Id.Resource)
 	if err != nil {
 		return nil, "", nil, wrapError(err, "failed to get access group")
@@ -120,6 +192,22 @@ func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 		users = append(users, accUser)
 	}
 
+	if hasEveryoneRule(group.Include) {
+		gr, err := allUsersGrant(resource, memberRole)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create all users grant")
+		}
+		rv = append(rv, gr)
+	}
+
+	var grantOptions []grant.GrantOption
+	if postureRules := postureRuleIDs(group.Include, group.Require, group.Exclude); len(postureRules) > 0 {
+		grantOptions = append(grantOptions, grant.WithGrantMetadata(map[string]interface{}{
+			"conditional":      true,
+			"posture_rule_ids": stringsProfileValue(postureRules),
+		}))
+	}
+
 	groupGrants := getAccessIncludeEmails(group.Include)
 	for _, user := range users {
 		userCopy := user
@@ -128,16 +216,128 @@ func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 			if err != nil {
 				return nil, "", nil, wrapError(err, "failed to create user resource")
 			}
-			gr := grant.NewGrant(resource, memberRole, ur.Id)
+			gr := grant.NewGrant(resource, memberRole, ur.Id, grantOptions...)
 			rv = append(rv, gr)
 		}
 	}
-	return rv, "", nil, nil
+
+	tokenGrants, tokensExpire, err := g.serviceTokenGrants(ctx, resource, group.Include)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	rv = append(rv, tokenGrants...)
+
+	if g.syncIDPGroups {
+		idpGrants, err := idpGroupGrants(resource, group.Include)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		rv = append(rv, idpGrants...)
+	}
+
+	return rv, "", g.changes.etag(entitlementID, since, tokensExpire), nil
+}
+
+// serviceTokenGrants returns a membership grant for every service token included in the group, either
+// by ID or through an any valid service token rule. Expired tokens can't authenticate, so their
+// memberships are marked as ineffective in the grant metadata, and the earliest expiry of the other
+// tokens is returned as the time the grants change.
+func (g *groupBuilder) serviceTokenGrants(ctx context.Context, resource *v2.Resource, include []interface{}) ([]*v2.Grant, time.Time, error) {
+	var (
+		tokenIDs = make(map[string]bool)
+		anyToken bool
+	)
+	for _, rule := range include {
+		switch ruleType, value := parseAccessRule(rule); ruleType {
+		case "service_token":
+			tokenIDs[value] = true
+		case "any_valid_service_token":
+			anyToken = true
+		}
+	}
+
+	if len(tokenIDs) == 0 && !anyToken {
+		return nil, time.Time{}, nil
+	}
+
+	tokens, err := g.caches.serviceTokens.get(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var (
+		rv      []*v2.Grant
+		expires time.Time
+	)
+	now := time.Now()
+	for _, token := range tokens {
+		if !anyToken && !tokenIDs[token.ID] {
+			continue
+		}
+
+		tokenID, err := rs.NewResourceID(serviceTokenResourceType, token.ID)
+		if err != nil {
+			return nil, time.Time{}, wrapError(err, "failed to create service token resource id")
+		}
+
+		expiryStatus, _ := serviceTokenExpiry(token, 0, now)
+		if expiryStatus != serviceTokenExpired && token.ExpiresAt != nil && (expires.IsZero() || token.ExpiresAt.Before(expires)) {
+			expires = *token.ExpiresAt
+		}
+		rv = append(rv, grant.NewGrant(resource, memberRole, tokenID, grant.WithGrantMetadata(map[string]interface{}{
+			"expiry_status": expiryStatus,
+			"effective":     expiryStatus != serviceTokenExpired,
+		})))
+	}
+
+	return rv, expires, nil
+}
+
+// allUsersGrants returns a membership grant of the synthetic all users group for every Access user.
+func (g *groupBuilder) allUsersGrants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: g.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users, info, err := g.client.ListAccessUsers(ctx, cloudflare.AccountIdentifier(g.accountId), cloudflare.AccessUserParams{
+		ResultInfo: cloudflare.ResultInfo{
+			Page:    page,
+			PerPage: resourcePageSize,
+		},
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list users")
+	}
+
+	rv := make([]*v2.Grant, 0, len(users))
+	for _, user := range users {
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+		rv = append(rv, grant.NewGrant(resource, memberRole, ur.Id))
+	}
+
+	if info.TotalPages <= info.Page {
+		return rv, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, nextPage, nil, nil
 }
 
 func (g *groupBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
 	l := ctxzap.Extract(ctx)
 
+	if entitlement.Resource.Id.Resource == allUsersGroupID {
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: membership of the all users group can't be granted")
+	}
+
 	if principal.Id.ResourceType != userResourceType.Id {
 		l.Warn(
 			"baton-cloudflare-zero-trust: only users can be granted group membership",
@@ -152,26 +352,39 @@ func (g *groupBuilder) Grant(ctx context.Context, principal *v2.Resource, entitl
 		return nil, wrapError(err, "unable to get email from user trait")
 	}
 
-	group, err := g.client.GetAccessGroup(ctx, cloudflare.AccountIdentifier(g.accountId), entitlement.Resource.Id.Resource)
+	err = addAccessGroupIncludeRule(ctx, g.client, g.accountId, entitlement.Resource.Id.Resource, map[string]interface{}{"email": map[string]interface{}{"email": email}})
 	if err != nil {
-		return nil, wrapError(err, "failed to get access group")
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: failed to add user to group: %w", err)
 	}
 
-	var grants []interface{}
-	// existing emails in group.
-	grants = append(grants, group.Include...)
-	// new access email to add to group.
-	grants = append(grants, map[string]interface{}{"email": map[string]interface{}{"email": email}})
+	return nil, nil
+}
 
-	_, err = g.client.UpdateAccessGroup(ctx, cloudflare.AccountIdentifier(g.accountId), cloudflare.UpdateAccessGroupParams{
-		ID:      entitlement.Resource.Id.Resource,
-		Include: grants,
+// addAccessGroupIncludeRule appends an include rule to an Access group, keeping its other rules.
+func addAccessGroupIncludeRule(ctx context.Context, client *cloudflare.API, accountId string, groupID string, rule interface{}) error {
+	group, err := client.GetAccessGroup(ctx, cloudflare.AccountIdentifier(accountId), groupID)
+	if err != nil {
+		return wrapError(err, "failed to get access group")
+	}
+
+	var include []interface{}
+	// existing rules in group.
+	include = append(include, group.Include...)
+	// new rule to add to group.
+	include = append(include, rule)
+
+	_, err = client.UpdateAccessGroup(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.UpdateAccessGroupParams{
+		ID:      groupID,
+		Name:    group.Name,
+		Include: include,
+		Exclude: group.Exclude,
+		Require: group.Require,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("baton-cloudflare-zero-trust: failed to add user to group: %w", err)
+		return err
 	}
 
-	return nil, nil
+	return nil
 }
 
 func (g *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
@@ -179,6 +392,10 @@ func (g *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 	principal := grant.Principal
 	entitlement := grant.Entitlement
 
+	if entitlement.Resource.Id.Resource == allUsersGroupID {
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: membership of the all users group can't be revoked")
+	}
+
 	if principal.Id.ResourceType != userResourceType.Id {
 		l.Warn(
 			"baton-cloudflare-zero-trust: only users can have group membership revoked",
@@ -198,31 +415,46 @@ func (g *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 		return nil, wrapError(err, "failed to get access group")
 	}
 
-	var grants []interface{}
-	// send only the grants that do not match the email to revoke.
-	for _, grant := range group.Include {
-		value := grant.(map[string]interface{})["email"].(map[string]interface{})["email"]
-		if value != email {
-			grants = append(grants, grant)
-		}
-	}
+	// send only the rules that do not match the email to revoke.
+	include, _ := removeEmailRules(group.Include, email)
 
 	_, err = g.client.UpdateAccessGroup(ctx, cloudflare.AccountIdentifier(g.accountId), cloudflare.UpdateAccessGroupParams{
 		ID:      entitlement.Resource.Id.Resource,
-		Include: grants,
+		Name:    group.Name,
+		Include: include,
+		Exclude: group.Exclude,
+		Require: group.Require,
 	})
 
 	if err != nil {
 		return nil, fmt.Errorf("baton-cloudflare-zero-trust: failed to remove user from group: %w", err)
 	}
 
+	if g.revokeSessions {
+		err = revokeAccessUserSessions(ctx, g.client, g.accountId, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return nil, nil
 }
 
-func newGroupBuilder(client *cloudflare.API, accountId string) *groupBuilder {
+func newGroupBuilder(
+	client *cloudflare.API,
+	accountId string,
+	revokeSessions bool,
+	syncIDPGroups bool,
+	changes *changeTracker,
+	caches *syncCaches,
+) *groupBuilder {
 	return &groupBuilder{
-		resourceType: groupResourceType,
-		client:       client,
-		accountId:    accountId,
+		resourceType:   groupResourceType,
+		client:         client,
+		accountId:      accountId,
+		revokeSessions: revokeSessions,
+		syncIDPGroups:  syncIDPGroups,
+		changes:        changes,
+		caches:         caches,
 	}
 }
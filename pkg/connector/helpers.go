@@ -1,12 +1,15 @@
 package connector
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
+	"github.com/cloudflare/cloudflare-go"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func annotationsForUserResourceType() annotations.Annotations {
@@ -82,3 +85,65 @@ func getEmailFromUserTrait(resource *v2.Resource) (string, error) {
 	}
 	return resource.DisplayName, nil
 }
+
+func getProfileBoolValue(profile *structpb.Struct, k string) (bool, bool) {
+	if profile == nil {
+		return false, false
+	}
+
+	v, ok := profile.Fields[k]
+	if !ok {
+		return false, false
+	}
+
+	b, ok := v.Kind.(*structpb.Value_BoolValue)
+	if !ok {
+		return false, false
+	}
+
+	return b.BoolValue, true
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// findAccessUser returns the Access user with the given email or ID.
+func findAccessUser(ctx context.Context, client *cloudflare.API, accountId string, user string) (*cloudflare.AccessUser, error) {
+	users, _, err := client.ListAccessUsers(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.AccessUserParams{})
+	if err != nil {
+		return nil, wrapError(err, "failed to list users")
+	}
+
+	for i := range users {
+		if users[i].ID == user || normalizeEmail(users[i].Email) == normalizeEmail(user) {
+			return &users[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("baton-cloudflare-zero-trust: access user %s not found", user)
+}
+
+// stringsProfileValue converts a string slice to a profile list value.
+func stringsProfileValue(values []string) []interface{} {
+	rv := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		rv = append(rv, value)
+	}
+
+	return rv
+}
+
+// removeEmailRules returns the rules without the email rules matching the email, and how many were removed.
+func removeEmailRules(rules []interface{}, email string) ([]interface{}, int) {
+	rv := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		ruleType, value := parseAccessRule(rule)
+		if ruleType == "email" && normalizeEmail(value) == normalizeEmail(email) {
+			continue
+		}
+		rv = append(rv, rule)
+	}
+
+	return rv, len(rules) - len(rv)
+}
@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+const (
+	identityProviderUserEntitlement = "user"
+
+	// identityProviderOneTimePin is the type of the one-time PIN login method, which only proves control of an email address.
+	identityProviderOneTimePin = "onetimepin"
+)
+
+type identityProviderBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	// identities attributes users to the identity provider they last authenticated with when set.
+	identities *identityCache
+	caches     *syncCaches
+}
+
+func (i *identityProviderBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return i.resourceType
+}
+
+// newIdentityProviderResource creates a new connector resource for an Access identity provider.
+func newIdentityProviderResource(idp cloudflare.AccessIdentityProvider) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"idp_id":                        idp.ID,
+		"idp_name":                      idp.Name,
+		"idp_type":                      idp.Type,
+		"scim_enabled":                  idp.ScimConfig.Enabled,
+		"scim_user_deprovision":         idp.ScimConfig.UserDeprovision,
+		"scim_seat_deprovision":         idp.ScimConfig.SeatDeprovision,
+		"scim_group_member_deprovision": idp.ScimConfig.GroupMemberDeprovision,
+	}
+
+	return rs.NewGroupResource(
+		idp.Name,
+		identityProviderResourceType,
+		idp.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+	)
+}
+
+// List returns the identity providers, or login methods, of the account.
+func (i *identityProviderBuilder) List(ctx context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	idps, _, err := i.client.ListAccessIdentityProviders(ctx, cloudflare.AccountIdentifier(i.accountId), cloudflare.ListAccessIdentityProvidersParams{})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access identity providers")
+	}
+
+	resources := make([]*v2.Resource, 0, len(idps))
+	for _, idp := range idps {
+		resource, err := newIdentityProviderResource(idp)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create identity provider resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+func (i *identityProviderBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	options := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s Identity Provider %s", resource.DisplayName, identityProviderUserEntitlement)),
+		ent.WithDescription(fmt.Sprintf("Last authenticated with %s identity provider", resource.DisplayName)),
+	}
+
+	return []*v2.Entitlement{ent.NewAssignmentEntitlement(resource, identityProviderUserEntitlement, options...)}, "", nil, nil
+}
+
+// Grants returns a grant for every Access user whose last seen identity comes from the identity provider.
+func (i *identityProviderBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	if i.identities == nil {
+		return nil, "", nil, nil
+	}
+
+	users, err := i.caches.accessUsers.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, user := range users {
+		identity, err := i.identities.get(ctx, user.ID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if identity == nil || identity.IDP.ID != resource.Id.Resource {
+			continue
+		}
+
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+		rv = append(rv, grant.NewGrant(resource, identityProviderUserEntitlement, ur.Id))
+	}
+
+	return rv, "", nil, nil
+}
+
+// loginMethodIDs returns the identity providers referenced by the login method rules of the rule sets.
+func loginMethodIDs(rules ...[]interface{}) []string {
+	var (
+		rv   []string
+		seen = make(map[string]bool)
+	)
+	for _, ruleSet := range rules {
+		for _, rule := range ruleSet {
+			ruleType, value := parseAccessRule(rule)
+			if ruleType != "login_method" || value == "" || seen[value] {
+				continue
+			}
+			seen[value] = true
+			rv = append(rv, value)
+		}
+	}
+
+	return rv
+}
+
+func newIdentityProviderBuilder(client *cloudflare.API, accountId string, identities *identityCache, caches *syncCaches) *identityProviderBuilder {
+	return &identityProviderBuilder{
+		resourceType: identityProviderResourceType,
+		client:       client,
+		accountId:    accountId,
+		identities:   identities,
+		caches:       caches,
+	}
+}
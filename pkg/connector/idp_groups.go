@@ -0,0 +1,287 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+// idpGroup is a group the identity provider reported for a user at their last login.
+type idpGroup struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// lastSeenIdentity is the identity Cloudflare last received from the identity provider of a user.
+// cloudflare-go doesn't decode the IdP groups of the identity.
+type lastSeenIdentity struct {
+	cloudflare.GetAccessUserLastSeenIdentityResult
+	Groups []idpGroup `json:"groups"`
+}
+
+// identityCache looks up the last seen identity of Access users.
+type identityCache = userCache[*lastSeenIdentity]
+
+func newIdentityCache(client *cloudflare.API, accountId string) *identityCache {
+	return newUserCache(func(ctx context.Context, userID string) (*lastSeenIdentity, error) {
+		return getLastSeenIdentity(ctx, client, accountId, userID)
+	})
+}
+
+// getLastSeenIdentity returns the last seen identity of the user, or nil if the user never logged in.
+func getLastSeenIdentity(ctx context.Context, client *cloudflare.API, accountId string, userID string) (*lastSeenIdentity, error) {
+	res, err := client.Raw(ctx, http.MethodGet, fmt.Sprintf("/accounts/%s/access/users/%s/last_seen_identity", accountId, userID), nil, nil)
+	if err != nil {
+		var notFound *cloudflare.NotFoundError
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+		return nil, wrapError(err, "failed to get access user last seen identity")
+	}
+
+	var identity lastSeenIdentity
+	err = json.Unmarshal(res.Result, &identity)
+	if err != nil {
+		return nil, wrapError(err, "failed to decode access user last seen identity")
+	}
+
+	return &identity, nil
+}
+
+// idpGroupRuleValue returns the value Access group rules use to reference a group of the identity provider:
+// Okta rules match group names, Azure AD rules group IDs and Google Workspace rules group emails.
+func idpGroupRuleValue(idpType string, group idpGroup) string {
+	switch idpType {
+	case "okta":
+		return group.Name
+	case "azureAD":
+		return group.ID
+	case "google-apps":
+		return group.Email
+	}
+
+	if group.ID != "" {
+		return group.ID
+	}
+	return group.Name
+}
+
+// idpGroupResourceID returns the ID of the idp_group resource for a group value of an identity provider.
+func idpGroupResourceID(idpID string, value string) string {
+	return idpID + "/" + value
+}
+
+// idpGroupFromRule returns the idp_group resource ID referenced by an Okta, Azure AD or Google Workspace group rule.
+func idpGroupFromRule(rule interface{}) (string, bool) {
+	rm, ok := rule.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+
+	for ruleType, key := range map[string]string{"okta": "name", "azureAD": "id", "gsuite": "email"} {
+		bm, ok := rm[ruleType].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		idpID, _ := bm["identity_provider_id"].(string)
+		value, _ := bm[key].(string)
+		if idpID == "" || value == "" {
+			return "", false
+		}
+
+		return idpGroupResourceID(idpID, value), true
+	}
+
+	return "", false
+}
+
+// idpGroupMembership is a group of an identity provider and the Access users it was reported for.
+type idpGroupMembership struct {
+	id      string
+	idpID   string
+	idpType string
+	group   idpGroup
+	members []cloudflare.AccessUser
+}
+
+// listIDPGroups returns the groups reported in the last seen identities of the users, by ID.
+func listIDPGroups(ctx context.Context, users []cloudflare.AccessUser, identities *identityCache) (map[string]*idpGroupMembership, error) {
+	groups := make(map[string]*idpGroupMembership)
+	for _, user := range users {
+		identity, err := identities.get(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if identity == nil || identity.IDP.ID == "" {
+			continue
+		}
+
+		for _, group := range identity.Groups {
+			value := idpGroupRuleValue(identity.IDP.Type, group)
+			if value == "" {
+				continue
+			}
+
+			id := idpGroupResourceID(identity.IDP.ID, value)
+			membership, ok := groups[id]
+			if !ok {
+				membership = &idpGroupMembership{
+					id:      id,
+					idpID:   identity.IDP.ID,
+					idpType: identity.IDP.Type,
+					group:   group,
+				}
+				groups[id] = membership
+			}
+			membership.members = append(membership.members, user)
+		}
+	}
+
+	return groups, nil
+}
+
+type idpGroupBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	caches       *syncCaches
+}
+
+func (i *idpGroupBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return i.resourceType
+}
+
+// newIDPGroupResource creates a new connector resource for a group of an identity provider.
+func newIDPGroupResource(membership *idpGroupMembership) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"idp_id":      membership.idpID,
+		"idp_type":    membership.idpType,
+		"group_id":    membership.group.ID,
+		"group_name":  membership.group.Name,
+		"group_email": membership.group.Email,
+	}
+
+	displayName := membership.group.Name
+	if displayName == "" {
+		displayName = strings.TrimPrefix(membership.id, membership.idpID+"/")
+	}
+
+	return rs.NewGroupResource(
+		displayName,
+		idpGroupResourceType,
+		membership.id,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(fmt.Sprintf("%s group of identity provider %s, as last reported for its members", membership.idpType, membership.idpID)),
+	)
+}
+
+// List returns the identity provider groups found in the last seen identities of the Access users.
+func (i *idpGroupBuilder) List(ctx context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	groups, err := i.caches.idpGroups.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	resources := make([]*v2.Resource, 0, len(groups))
+	for _, id := range ids {
+		resource, err := newIDPGroupResource(groups[id])
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create idp group resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+func (i *idpGroupBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	options := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s IdP Group %s", resource.DisplayName, memberRole)),
+		ent.WithDescription(fmt.Sprintf("%s of %s identity provider group", memberRole, resource.DisplayName)),
+	}
+
+	return []*v2.Entitlement{ent.NewAssignmentEntitlement(resource, memberRole, options...)}, "", nil, nil
+}
+
+// Grants returns a membership grant for every Access user whose last seen identity lists the group. The
+// memberships of every group are computed once per sync.
+func (i *idpGroupBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	groups, err := i.caches.idpGroups.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	group, ok := groups[resource.Id.Resource]
+	if !ok {
+		return nil, "", nil, nil
+	}
+
+	rv := make([]*v2.Grant, 0, len(group.members))
+	for _, user := range group.members {
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+		rv = append(rv, grant.NewGrant(resource, memberRole, ur.Id))
+	}
+
+	return rv, "", nil, nil
+}
+
+// idpGroupGrants returns an expandable membership grant to every identity provider group included in an Access group.
+func idpGroupGrants(resource *v2.Resource, include []interface{}) ([]*v2.Grant, error) {
+	var rv []*v2.Grant
+	for _, rule := range include {
+		id, ok := idpGroupFromRule(rule)
+		if !ok {
+			continue
+		}
+
+		idpGroupID, err := rs.NewResourceID(idpGroupResourceType, id)
+		if err != nil {
+			return nil, wrapError(err, "failed to create idp group resource id")
+		}
+
+		rv = append(rv, grant.NewGrant(
+			resource,
+			memberRole,
+			idpGroupID,
+			grant.WithAnnotation(&v2.GrantExpandable{
+				EntitlementIds: []string{ent.NewEntitlementID(&v2.Resource{Id: idpGroupID}, memberRole)},
+			}),
+		))
+	}
+
+	return rv, nil
+}
+
+func newIDPGroupBuilder(client *cloudflare.API, accountId string, caches *syncCaches) *idpGroupBuilder {
+	return &idpGroupBuilder{
+		resourceType: idpGroupResourceType,
+		client:       client,
+		accountId:    accountId,
+		caches:       caches,
+	}
+}
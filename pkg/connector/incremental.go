@@ -0,0 +1,291 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// Kinds of audit log resources that don't produce events but change the grants of other resources.
+const (
+	auditResourceServiceToken = "service_token"
+	auditResourceTeamsList    = "teams_list"
+)
+
+const (
+	// changeTrackerSkew widens the audit log window read for changes, for clock skew and late records.
+	changeTrackerSkew = 5 * time.Minute
+	// changeTrackerRefresh is how long the audit log read is trusted before its newer records are read.
+	changeTrackerRefresh = 5 * time.Minute
+	auditLogPageSize     = 1000
+)
+
+// syncETag is the value of the ETag of grants synced in full: the newest audit log record when they were
+// synced, when they were synced, and when they change without any audit log record, e.g. because a service
+// token they are held by expires.
+type syncETag struct {
+	// Since is the time of the newest audit log record before the grants were read. Changes are looked for
+	// from there, so they don't depend on the local clock.
+	Since time.Time `json:"since"`
+	// SyncedAt is when the grants were read, on the local clock, to tell how old they are.
+	SyncedAt time.Time  `json:"synced_at"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// auditChange is a successful change of the account audit log.
+type auditChange struct {
+	kind string
+	id   string
+	when time.Time
+}
+
+// changeTracker lets resources carry their grants forward from the previous sync when the account audit log
+// records no change that could affect them since they were last synced in full. The time of the newest audit
+// log record when they were synced is kept in the ETag of the resource, and the syncer copies the previous
+// grants on an ETagMatch.
+type changeTracker struct {
+	client    *cloudflare.API
+	accountId string
+	// maxAge is how old grants can get before they are synced in full again.
+	maxAge time.Duration
+
+	mu        sync.Mutex
+	from      time.Time
+	fetchedAt time.Time
+	changes   []auditChange
+
+	// newest is the time of the newest audit log record, read at newestAt.
+	newest   time.Time
+	newestAt time.Time
+}
+
+func newChangeTracker(client *cloudflare.API, accountId string, maxAge time.Duration) *changeTracker {
+	return &changeTracker{
+		client:    client,
+		accountId: accountId,
+		maxAge:    maxAge,
+	}
+}
+
+// watermark returns the time of the newest record of the audit log, to be read before the grants it is
+// recorded with. Records newer than the watermark may change the grants, so an older watermark only makes
+// changes more likely to be found. It returns the zero time when incremental sync is disabled, or when the
+// audit log is empty or can't be read.
+func (c *changeTracker) watermark(ctx context.Context) time.Time {
+	if c == nil {
+		return time.Time{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.newestAt.IsZero() && time.Since(c.newestAt) <= changeTrackerRefresh {
+		return c.newest
+	}
+
+	readAt := time.Now()
+	res, err := c.client.GetOrganizationAuditLogs(ctx, c.accountId, cloudflare.AuditLogFilter{
+		Direction: "desc",
+		PerPage:   1,
+	})
+	if err != nil {
+		ctxzap.Extract(ctx).Warn(
+			"baton-cloudflare-zero-trust: failed to read the newest audit log record, syncing grants in full",
+			zap.Error(err),
+		)
+		return time.Time{}
+	}
+	if len(res.Result) == 0 {
+		return time.Time{}
+	}
+
+	c.newest = res.Result[0].When
+	c.newestAt = readAt
+
+	return c.newest
+}
+
+// etag returns the annotations recording that the grants of entitlementID were synced in full after the
+// audit log record of since and, unless expires is zero, change on their own at expires. It returns nil
+// when incremental sync is disabled or since is zero.
+func (c *changeTracker) etag(entitlementID string, since time.Time, expires time.Time) annotations.Annotations {
+	if c == nil || since.IsZero() {
+		return nil
+	}
+
+	value := syncETag{Since: since.UTC(), SyncedAt: time.Now().UTC()}
+	if !expires.IsZero() {
+		expires = expires.UTC()
+		value.Expires = &expires
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	var annos annotations.Annotations
+	annos.Update(&v2.ETag{
+		Value:         string(data),
+		EntitlementId: entitlementID,
+	})
+
+	return annos
+}
+
+// carryForward returns the annotations matching the previous ETag of the resource when its grants of
+// entitlementID can be carried forward from the previous sync: they were synced in full less than maxAge
+// ago, and the audit log records no change after the watermark of that sync to the resource itself, of the
+// given kind, nor to any resource of the dependency kinds. Otherwise the grants have to be synced in full.
+func (c *changeTracker) carryForward(
+	ctx context.Context,
+	resource *v2.Resource,
+	entitlementID string,
+	kind string,
+	dependencies ...string,
+) (annotations.Annotations, bool) {
+	if c == nil {
+		return nil, false
+	}
+	l := ctxzap.Extract(ctx)
+
+	resourceAnnos := annotations.Annotations(resource.Annotations)
+	prev := &v2.ETag{}
+	ok, err := resourceAnnos.Pick(prev)
+	if err != nil || !ok || prev.EntitlementId != entitlementID {
+		return nil, false
+	}
+
+	var value syncETag
+	err = json.Unmarshal([]byte(prev.Value), &value)
+	if err != nil {
+		l.Debug(
+			"baton-cloudflare-zero-trust: ignoring unknown etag",
+			zap.String("resource_id", resource.Id.Resource),
+			zap.String("etag", prev.Value),
+		)
+		return nil, false
+	}
+
+	now := time.Now()
+	if value.Since.IsZero() || now.Sub(value.SyncedAt) > c.maxAge {
+		l.Debug(
+			"baton-cloudflare-zero-trust: grants are too old to carry forward, syncing them in full",
+			zap.String("resource_id", resource.Id.Resource),
+			zap.Time("synced_at", value.SyncedAt),
+		)
+		return nil, false
+	}
+	if value.Expires != nil && !now.Before(*value.Expires) {
+		return nil, false
+	}
+
+	changed, err := c.changed(ctx, value.Since, resource.Id.Resource, kind, dependencies)
+	if err != nil {
+		l.Warn(
+			"baton-cloudflare-zero-trust: failed to read changes from the audit log, syncing grants in full",
+			zap.String("resource_id", resource.Id.Resource),
+			zap.Error(err),
+		)
+		return nil, false
+	}
+	if changed {
+		return nil, false
+	}
+
+	var annos annotations.Annotations
+	annos.Update(&v2.ETagMatch{EntitlementId: entitlementID})
+
+	return annos, true
+}
+
+// changed reports whether the audit log records a change since the given time to the resource of kind with
+// the given ID, or to any resource of the dependency kinds.
+func (c *changeTracker) changed(ctx context.Context, since time.Time, id string, kind string, dependencies []string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from := since.Add(-changeTrackerSkew)
+	err := c.fetch(ctx, from)
+	if err != nil {
+		return false, err
+	}
+
+	for _, change := range c.changes {
+		if change.when.Before(from) {
+			continue
+		}
+		if change.kind == kind && change.id == id {
+			return true, nil
+		}
+		if groupContainsUser(change.kind, dependencies) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// fetch reads the changes of the audit log from the given time that haven't been read yet, and the ones
+// recorded since the last read when it is older than changeTrackerRefresh.
+func (c *changeTracker) fetch(ctx context.Context, from time.Time) error {
+	now := time.Now()
+	switch {
+	case c.fetchedAt.IsZero() || from.Before(c.from):
+		changes, err := c.listChanges(ctx, from)
+		if err != nil {
+			return err
+		}
+		c.from = from
+		c.changes = changes
+	case now.Sub(c.fetchedAt) > changeTrackerRefresh:
+		changes, err := c.listChanges(ctx, c.fetchedAt.Add(-changeTrackerSkew))
+		if err != nil {
+			return err
+		}
+		c.changes = append(c.changes, changes...)
+	default:
+		return nil
+	}
+	c.fetchedAt = now
+
+	return nil
+}
+
+// listChanges returns the successful changes of the audit log since the given time.
+func (c *changeTracker) listChanges(ctx context.Context, since time.Time) ([]auditChange, error) {
+	var rv []auditChange
+	for page := 1; ; page++ {
+		res, err := c.client.GetOrganizationAuditLogs(ctx, c.accountId, cloudflare.AuditLogFilter{
+			Direction: "asc",
+			Since:     since.UTC().Format(time.RFC3339),
+			PerPage:   auditLogPageSize,
+			Page:      page,
+		})
+		if err != nil {
+			return nil, wrapError(err, "failed to list audit logs")
+		}
+
+		for _, record := range res.Result {
+			kind := auditResourceKind(record)
+			if kind == "" {
+				continue
+			}
+			rv = append(rv, auditChange{
+				kind: kind,
+				id:   record.Resource.ID,
+				when: record.When,
+			})
+		}
+
+		if len(res.Result) < auditLogPageSize {
+			return rv, nil
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const approverEntitlement = "approver"
+
+type policyBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	// changes carries approvers forward from the previous sync when incremental sync is enabled.
+	changes *changeTracker
+	caches  *syncCaches
+}
+
+func (p *policyBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return p.resourceType
+}
+
+// newPolicyResource creates a new connector resource for a Cloudflare Access policy of an application.
+func newPolicyResource(policy cloudflare.AccessPolicy, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	approvalRequired := policy.ApprovalRequired != nil && *policy.ApprovalRequired
+	approvalsNeeded := 0
+	for _, approvalGroup := range policy.ApprovalGroups {
+		approvalsNeeded += approvalGroup.ApprovalsNeeded
+	}
+
+	profile := map[string]interface{}{
+		"policy_id":         policy.ID,
+		"policy_name":       policy.Name,
+		"decision":          policy.Decision,
+		"precedence":        policy.Precedence,
+		"application_id":    parentResourceID.Resource,
+		"approval_required": approvalRequired,
+		"approval_groups":   len(policy.ApprovalGroups),
+		"approvals_needed":  approvalsNeeded,
+		"risk_flags":        stringsProfileValue(policyRiskFlags(policy)),
+		"posture_rule_ids":  stringsProfileValue(postureRuleIDs(policy.Include, policy.Require, policy.Exclude)),
+		"login_methods":     stringsProfileValue(loginMethodIDs(policy.Include, policy.Require)),
+		// Login methods of exclude rules keep users out of the policy, so they are kept apart.
+		"excluded_login_methods": stringsProfileValue(loginMethodIDs(policy.Exclude)),
+	}
+
+	groupTraitOptions := []rs.GroupTraitOption{
+		rs.WithGroupProfile(profile),
+	}
+
+	ret, err := rs.NewGroupResource(
+		policy.Name,
+		policyResourceType,
+		policy.ID,
+		groupTraitOptions,
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// List returns all the Access policies of an application as resource objects.
+func (p *policyBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil {
+		return nil, "", nil, nil
+	}
+
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: p.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	policies, info, err := p.client.ListAccessPolicies(ctx, cloudflare.AccountIdentifier(p.accountId), cloudflare.ListAccessPoliciesParams{
+		ApplicationID: parentResourceID.Resource,
+		ResultInfo: cloudflare.ResultInfo{
+			Page:    page,
+			PerPage: resourcePageSize,
+		},
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access policies")
+	}
+
+	resources := make([]*v2.Resource, 0, len(policies))
+	for _, policy := range policies {
+		resource, err := newPolicyResource(policy, parentResourceID)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create policy resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	if info.TotalPages <= info.Page {
+		return resources, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return resources, nextPage, nil, nil
+}
+
+// Entitlements returns the approver entitlement for policies that require approval.
+func (p *policyBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	trait, err := rs.GetGroupTrait(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	approvalRequired, _ := getProfileBoolValue(trait.Profile, "approval_required")
+	if !approvalRequired {
+		return nil, "", nil, nil
+	}
+
+	options := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s Policy %s", resource.DisplayName, approverEntitlement)),
+		ent.WithDescription(fmt.Sprintf("Can approve access requests for %s Cloudflare policy", resource.DisplayName)),
+	}
+
+	return []*v2.Entitlement{ent.NewPermissionEntitlement(resource, approverEntitlement, options...)}, "", nil, nil
+}
+
+// Grants returns an approver grant for every Access user listed in the approval groups of the policy,
+// either directly by email or through an email list. Approvers follow the policy and the email lists, but
+// those who aren't Access users yet are granted on their first login, which the audit log doesn't record.
+func (p *policyBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if resource.ParentResourceId == nil {
+		return nil, "", nil, nil
+	}
+
+	entitlementID := ent.NewEntitlementID(resource, approverEntitlement)
+	since := p.changes.watermark(ctx)
+	annos, ok := p.changes.carryForward(ctx, resource, entitlementID, auditResourceAccessPolicy, auditResourceTeamsList)
+	if ok {
+		return nil, "", annos, nil
+	}
+	annos = p.changes.etag(entitlementID, since, time.Time{})
+
+	policy, err := p.client.GetAccessPolicy(ctx, cloudflare.AccountIdentifier(p.accountId), cloudflare.GetAccessPolicyParams{
+		ApplicationID: resource.ParentResourceId.Resource,
+		PolicyID:      resource.Id.Resource,
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to get access policy")
+	}
+
+	if policy.ApprovalRequired == nil || !*policy.ApprovalRequired {
+		return nil, "", annos, nil
+	}
+
+	approvers, err := p.getApproverEmails(ctx, policy.ApprovalGroups)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if len(approvers) == 0 {
+		return nil, "", annos, nil
+	}
+
+	users, err := p.caches.accessUsersByEmail.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, email := range approvers {
+		user, ok := users[normalizeEmail(email)]
+		if !ok {
+			l.Debug(
+				"baton-cloudflare-zero-trust: approver is not an access user",
+				zap.String("policy_id", policy.ID),
+				zap.String("email", email),
+			)
+			// Synced in full until the approver logs in.
+			annos = nil
+			continue
+		}
+
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+
+		rv = append(rv, grant.NewGrant(resource, approverEntitlement, ur.Id))
+	}
+
+	return rv, "", annos, nil
+}
+
+// getApproverEmails returns the unique emails of the approval groups, expanding email lists into their items.
+func (p *policyBuilder) getApproverEmails(ctx context.Context, approvalGroups []cloudflare.AccessApprovalGroup) ([]string, error) {
+	var (
+		emails []string
+		seen   = make(map[string]bool)
+	)
+	addEmail := func(email string) {
+		key := normalizeEmail(email)
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		emails = append(emails, email)
+	}
+
+	for _, approvalGroup := range approvalGroups {
+		for _, email := range approvalGroup.EmailAddresses {
+			addEmail(email)
+		}
+
+		if approvalGroup.EmailListUuid == "" {
+			continue
+		}
+
+		items, _, err := p.client.ListTeamsListItems(ctx, cloudflare.AccountIdentifier(p.accountId), cloudflare.ListTeamsListItemsParams{
+			ListID: approvalGroup.EmailListUuid,
+		})
+		if err != nil {
+			return nil, wrapError(err, "failed to list email list items")
+		}
+
+		for _, item := range items {
+			addEmail(item.Value)
+		}
+	}
+
+	return emails, nil
+}
+
+func newPolicyBuilder(client *cloudflare.API, accountId string, changes *changeTracker, caches *syncCaches) *policyBuilder {
+	return &policyBuilder{
+		resourceType: policyResourceType,
+		client:       client,
+		accountId:    accountId,
+		changes:      changes,
+		caches:       caches,
+	}
+}
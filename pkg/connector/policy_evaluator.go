@@ -0,0 +1,442 @@
+package connector
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// ruleResult is the tri-state outcome of evaluating an Access rule for an identity.
+// Rules that depend on request context (IP, geo, device posture, ...) can't be
+// evaluated offline and yield ruleConditional.
+type ruleResult int
+
+const (
+	ruleNoMatch ruleResult = iota
+	ruleMatch
+	ruleConditional
+)
+
+const (
+	policyDecisionAllow       = "allow"
+	policyDecisionDeny        = "deny"
+	policyDecisionBypass      = "bypass"
+	policyDecisionNonIdentity = "non_identity"
+)
+
+// accessIdentity holds the identity attributes a policy is evaluated against.
+type accessIdentity struct {
+	Email          string
+	ServiceTokenID string
+}
+
+// ruleTrace records the outcome of a single rule, including the group rules it went through.
+type ruleTrace struct {
+	Rule   string      `json:"rule"`
+	Value  string      `json:"value,omitempty"`
+	Result string      `json:"result"`
+	Nested []ruleTrace `json:"nested,omitempty"`
+}
+
+// policyTrace records how a single policy was evaluated.
+type policyTrace struct {
+	PolicyID   string      `json:"policy_id"`
+	PolicyName string      `json:"policy_name"`
+	Decision   string      `json:"decision"`
+	Precedence int         `json:"precedence"`
+	Result     string      `json:"result"`
+	Include    []ruleTrace `json:"include,omitempty"`
+	Require    []ruleTrace `json:"require,omitempty"`
+	Exclude    []ruleTrace `json:"exclude,omitempty"`
+}
+
+// policyEvaluation is the effective access of an identity to an application.
+type policyEvaluation struct {
+	Allowed     bool          `json:"allowed"`
+	Conditional bool          `json:"conditional"`
+	Conditions  []string      `json:"conditions,omitempty"`
+	PolicyID    string        `json:"policy_id,omitempty"`
+	PolicyName  string        `json:"policy_name,omitempty"`
+	Decision    string        `json:"decision,omitempty"`
+	Trace       []policyTrace `json:"trace,omitempty"`
+}
+
+// policyEvaluator evaluates Access policies locally using the groups and email lists of the account.
+// Email lists are fetched the first time a policy or group referencing them is prepared, so a single
+// evaluator can be shared by every application of a sync.
+type policyEvaluator struct {
+	client    *cloudflare.API
+	accountId string
+	groups    map[string]cloudflare.AccessGroup
+
+	mu         sync.RWMutex
+	emailLists map[string]map[string]bool
+}
+
+func (r ruleResult) String() string {
+	switch r {
+	case ruleMatch:
+		return "match"
+	case ruleConditional:
+		return "conditional"
+	default:
+		return "no_match"
+	}
+}
+
+// newPolicyEvaluator fetches the Access groups of the account and the email lists referenced by
+// the given policies or groups.
+func newPolicyEvaluator(ctx context.Context, client *cloudflare.API, accountId string, policies []cloudflare.AccessPolicy) (*policyEvaluator, error) {
+	groups, _, err := client.ListAccessGroups(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.ListAccessGroupsParams{})
+	if err != nil {
+		return nil, wrapError(err, "failed to list access groups")
+	}
+
+	e := &policyEvaluator{
+		client:     client,
+		accountId:  accountId,
+		groups:     make(map[string]cloudflare.AccessGroup, len(groups)),
+		emailLists: make(map[string]map[string]bool),
+	}
+
+	var rules []interface{}
+	for _, group := range groups {
+		e.groups[group.ID] = group
+		rules = append(rules, group.Include...)
+		rules = append(rules, group.Require...)
+		rules = append(rules, group.Exclude...)
+	}
+
+	err = e.loadEmailLists(ctx, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.prepare(ctx, policies)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// prepare fetches the email lists referenced by the policies that haven't been fetched yet.
+func (e *policyEvaluator) prepare(ctx context.Context, policies []cloudflare.AccessPolicy) error {
+	var rules []interface{}
+	for _, policy := range policies {
+		rules = append(rules, policy.Include...)
+		rules = append(rules, policy.Require...)
+		rules = append(rules, policy.Exclude...)
+	}
+
+	return e.loadEmailLists(ctx, rules)
+}
+
+func (e *policyEvaluator) loadEmailLists(ctx context.Context, rules []interface{}) error {
+	for _, rule := range rules {
+		ruleType, value := parseAccessRule(rule)
+		if ruleType != "email_list" || value == "" {
+			continue
+		}
+
+		e.mu.RLock()
+		_, ok := e.emailLists[value]
+		e.mu.RUnlock()
+		if ok {
+			continue
+		}
+
+		items, _, err := e.client.ListTeamsListItems(ctx, cloudflare.AccountIdentifier(e.accountId), cloudflare.ListTeamsListItemsParams{
+			ListID: value,
+		})
+		if err != nil {
+			return wrapError(err, "failed to list email list items")
+		}
+
+		emails := make(map[string]bool, len(items))
+		for _, item := range items {
+			emails[normalizeEmail(item.Value)] = true
+		}
+
+		e.mu.Lock()
+		e.emailLists[value] = emails
+		e.mu.Unlock()
+	}
+
+	return nil
+}
+
+// inEmailList reports whether the normalized email is an item of the email list.
+func (e *policyEvaluator) inEmailList(listID string, email string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.emailLists[listID][email]
+}
+
+// parseAccessRule returns the type of an Access rule and its identifying value, if any.
+func parseAccessRule(rule interface{}) (string, string) {
+	rm, ok := rule.(map[string]interface{})
+	if !ok {
+		return "", ""
+	}
+
+	for ruleType, body := range rm {
+		bm, ok := body.(map[string]interface{})
+		if !ok {
+			return ruleType, ""
+		}
+
+		for _, key := range []string{"email", "domain", "id", "token_id", "email_domain", "name", "integration_uid", "identity_provider_id"} {
+			if value, ok := bm[key].(string); ok {
+				return ruleType, value
+			}
+		}
+
+		return ruleType, ""
+	}
+
+	return "", ""
+}
+
+// evaluateRule evaluates a single Access rule, following nested groups. visiting guards against group cycles.
+func (e *policyEvaluator) evaluateRule(rule interface{}, identity accessIdentity, visiting map[string]bool) (ruleResult, ruleTrace) {
+	ruleType, value := parseAccessRule(rule)
+	trace := ruleTrace{Rule: ruleType, Value: value}
+
+	result := ruleNoMatch
+	email := normalizeEmail(identity.Email)
+	switch ruleType {
+	case "everyone":
+		if email != "" {
+			result = ruleMatch
+		}
+	case "email":
+		if email != "" && email == normalizeEmail(value) {
+			result = ruleMatch
+		}
+	case "email_domain":
+		domain := strings.TrimPrefix(normalizeEmail(value), "@")
+		if email != "" && domain != "" && strings.HasSuffix(email, "@"+domain) {
+			result = ruleMatch
+		}
+	case "email_list":
+		if email != "" && e.inEmailList(value, email) {
+			result = ruleMatch
+		}
+	case "service_token":
+		if identity.ServiceTokenID != "" && identity.ServiceTokenID == value {
+			result = ruleMatch
+		}
+	case "any_valid_service_token":
+		if identity.ServiceTokenID != "" {
+			result = ruleMatch
+		}
+	case "group":
+		group, ok := e.groups[value]
+		if !ok || visiting[value] {
+			break
+		}
+		trace.Value = group.Name
+
+		visiting[value] = true
+		var include, require, exclude []ruleTrace
+		result, include, require, exclude = e.evaluateRules(group.Include, group.Require, group.Exclude, identity, visiting)
+		delete(visiting, value)
+
+		trace.Nested = append(trace.Nested, include...)
+		trace.Nested = append(trace.Nested, require...)
+		trace.Nested = append(trace.Nested, exclude...)
+	default:
+		// ip, ip_list, geo, device_posture, external_evaluation, login_method, IdP group rules
+		// and any rule type we don't know about depend on the request or the IdP session.
+		result = ruleConditional
+	}
+
+	trace.Result = result.String()
+	return result, trace
+}
+
+// evaluateRules combines the include (OR), require (AND) and exclude (NOT) rules of a policy or group.
+func (e *policyEvaluator) evaluateRules(
+	include, require, exclude []interface{},
+	identity accessIdentity,
+	visiting map[string]bool,
+) (ruleResult, []ruleTrace, []ruleTrace, []ruleTrace) {
+	var includeTraces, requireTraces, excludeTraces []ruleTrace
+
+	includeResult := ruleNoMatch
+	for _, rule := range include {
+		result, trace := e.evaluateRule(rule, identity, visiting)
+		includeTraces = append(includeTraces, trace)
+		if result == ruleMatch {
+			includeResult = ruleMatch
+			break
+		}
+		if result == ruleConditional {
+			includeResult = ruleConditional
+		}
+	}
+
+	requireResult := ruleMatch
+	for _, rule := range require {
+		result, trace := e.evaluateRule(rule, identity, visiting)
+		requireTraces = append(requireTraces, trace)
+		if result == ruleNoMatch {
+			requireResult = ruleNoMatch
+			break
+		}
+		if result == ruleConditional {
+			requireResult = ruleConditional
+		}
+	}
+
+	excludeResult := ruleNoMatch
+	for _, rule := range exclude {
+		result, trace := e.evaluateRule(rule, identity, visiting)
+		excludeTraces = append(excludeTraces, trace)
+		if result == ruleMatch {
+			excludeResult = ruleMatch
+			break
+		}
+		if result == ruleConditional {
+			excludeResult = ruleConditional
+		}
+	}
+
+	switch {
+	case includeResult == ruleNoMatch, requireResult == ruleNoMatch, excludeResult == ruleMatch:
+		return ruleNoMatch, includeTraces, requireTraces, excludeTraces
+	case includeResult == ruleConditional, requireResult == ruleConditional, excludeResult == ruleConditional:
+		return ruleConditional, includeTraces, requireTraces, excludeTraces
+	default:
+		return ruleMatch, includeTraces, requireTraces, excludeTraces
+	}
+}
+
+// evaluate walks the policies of an application in precedence order. The first policy that definitely
+// matches decides; policies that only conditionally match before it make the outcome conditional.
+func (e *policyEvaluator) evaluate(policies []cloudflare.AccessPolicy, identity accessIdentity) policyEvaluation {
+	sorted := make([]cloudflare.AccessPolicy, len(policies))
+	copy(sorted, policies)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Precedence < sorted[j].Precedence
+	})
+
+	var (
+		rv              policyEvaluation
+		conditionalPass *cloudflare.AccessPolicy
+		conditionalDeny bool
+		conditions      = make(map[string]bool)
+	)
+	for i := range sorted {
+		policy := sorted[i]
+		result, include, require, exclude := e.evaluateRules(policy.Include, policy.Require, policy.Exclude, identity, make(map[string]bool))
+		if identity.ServiceTokenID != "" && policy.Decision == policyDecisionAllow {
+			// Allow policies send requests to the login page, only service auth and bypass policies let
+			// service tokens in.
+			result = ruleNoMatch
+		}
+		rv.Trace = append(rv.Trace, policyTrace{
+			PolicyID:   policy.ID,
+			PolicyName: policy.Name,
+			Decision:   policy.Decision,
+			Precedence: policy.Precedence,
+			Result:     result.String(),
+			Include:    include,
+			Require:    require,
+			Exclude:    exclude,
+		})
+
+		if result == ruleNoMatch {
+			continue
+		}
+
+		if result == ruleConditional {
+			collectConditions(conditions, include, require, exclude)
+			if policy.Decision == policyDecisionDeny {
+				conditionalDeny = true
+			} else if conditionalPass == nil {
+				conditionalPass = &sorted[i]
+			}
+			continue
+		}
+
+		rv.PolicyID = policy.ID
+		rv.PolicyName = policy.Name
+		rv.Decision = policy.Decision
+		if policy.Decision == policyDecisionDeny {
+			if conditionalPass != nil {
+				rv.Allowed = true
+				rv.Conditional = true
+				rv.PolicyID = conditionalPass.ID
+				rv.PolicyName = conditionalPass.Name
+				rv.Decision = conditionalPass.Decision
+			}
+		} else {
+			rv.Allowed = true
+			rv.Conditional = conditionalDeny
+		}
+		rv.Conditions = sortedKeys(conditions)
+		return rv
+	}
+
+	if conditionalPass != nil {
+		rv.Allowed = true
+		rv.Conditional = true
+		rv.PolicyID = conditionalPass.ID
+		rv.PolicyName = conditionalPass.Name
+		rv.Decision = conditionalPass.Decision
+	}
+	rv.Conditions = sortedKeys(conditions)
+	return rv
+}
+
+// metadata returns the grant metadata describing how access was decided.
+func (p policyEvaluation) metadata() map[string]interface{} {
+	conditions := make([]interface{}, 0, len(p.Conditions))
+	for _, condition := range p.Conditions {
+		conditions = append(conditions, condition)
+	}
+
+	return map[string]interface{}{
+		"policy_id":   p.PolicyID,
+		"policy_name": p.PolicyName,
+		"decision":    p.Decision,
+		"conditional": p.Conditional,
+		"conditions":  conditions,
+	}
+}
+
+// collectConditions gathers the rule types that made an evaluation conditional.
+func collectConditions(conditions map[string]bool, traces ...[]ruleTrace) {
+	for _, ts := range traces {
+		for _, t := range ts {
+			if t.Result != ruleConditional.String() {
+				continue
+			}
+			if len(t.Nested) > 0 {
+				collectConditions(conditions, t.Nested)
+				continue
+			}
+			conditions[t.Rule] = true
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	rv := make([]string, 0, len(m))
+	for k := range m {
+		rv = append(rv, k)
+	}
+	sort.Strings(rv)
+
+	return rv
+}
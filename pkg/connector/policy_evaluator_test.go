@@ -0,0 +1,168 @@
+package connector
+
+import (
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func rule(ruleType string, body map[string]interface{}) interface{} {
+	return map[string]interface{}{ruleType: body}
+}
+
+func rules(r ...interface{}) []interface{} {
+	return r
+}
+
+var (
+	ruleEveryone     = rule("everyone", map[string]interface{}{})
+	ruleAlice        = rule("email", map[string]interface{}{"email": "Alice@Example.com"})
+	ruleBob          = rule("email", map[string]interface{}{"email": "bob@example.com"})
+	ruleExampleCom   = rule("email_domain", map[string]interface{}{"domain": "example.com"})
+	ruleContractors  = rule("email_list", map[string]interface{}{"id": "contractors"})
+	ruleEngineering  = rule("group", map[string]interface{}{"id": "engineering"})
+	ruleCycle        = rule("group", map[string]interface{}{"id": "cycle"})
+	ruleIP           = rule("ip", map[string]interface{}{"ip": "192.0.2.0/24"})
+	ruleUnknown      = rule("future_rule", map[string]interface{}{"value": "x"})
+	ruleToken        = rule("service_token", map[string]interface{}{"token_id": "token-1"})
+	ruleAnyToken     = rule("any_valid_service_token", map[string]interface{}{})
+	alice            = accessIdentity{Email: "alice@example.com"}
+	carol            = accessIdentity{Email: "carol@contractor.net"}
+	tokenIdentity    = accessIdentity{ServiceTokenID: "token-1"}
+	otherTokenIdenty = accessIdentity{ServiceTokenID: "token-2"}
+)
+
+func newTestEvaluator() *policyEvaluator {
+	return &policyEvaluator{
+		groups: map[string]cloudflare.AccessGroup{
+			"engineering": {ID: "engineering", Name: "Engineering", Include: rules(ruleAlice, ruleBob)},
+			"cycle":       {ID: "cycle", Name: "Cycle", Include: rules(ruleCycle)},
+		},
+		emailLists: map[string]map[string]bool{
+			"contractors": {"carol@contractor.net": true},
+		},
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []interface{}
+		require  []interface{}
+		exclude  []interface{}
+		identity accessIdentity
+		want     ruleResult
+	}{
+		{name: "no rules", identity: alice, want: ruleNoMatch},
+		{name: "include email", include: rules(ruleAlice), identity: alice, want: ruleMatch},
+		{name: "include other email", include: rules(ruleBob), identity: alice, want: ruleNoMatch},
+		{name: "include is an or", include: rules(ruleBob, ruleAlice), identity: alice, want: ruleMatch},
+		{name: "include everyone", include: rules(ruleEveryone), identity: alice, want: ruleMatch},
+		{name: "include email domain", include: rules(ruleExampleCom), identity: alice, want: ruleMatch},
+		{name: "include email domain of another domain", include: rules(ruleExampleCom), identity: carol, want: ruleNoMatch},
+		{name: "include email list", include: rules(ruleContractors), identity: carol, want: ruleMatch},
+		{name: "include email list without the email", include: rules(ruleContractors), identity: alice, want: ruleNoMatch},
+		{name: "include group", include: rules(ruleEngineering), identity: alice, want: ruleMatch},
+		{name: "include group without the email", include: rules(ruleEngineering), identity: carol, want: ruleNoMatch},
+		{name: "include group cycle", include: rules(ruleCycle), identity: alice, want: ruleNoMatch},
+		{name: "include conditional", include: rules(ruleIP), identity: alice, want: ruleConditional},
+		{name: "include match wins over conditional", include: rules(ruleIP, ruleAlice), identity: alice, want: ruleMatch},
+		{name: "require is an and", include: rules(ruleEveryone), require: rules(ruleExampleCom, ruleAlice), identity: alice, want: ruleMatch},
+		{name: "require not met", include: rules(ruleEveryone), require: rules(ruleExampleCom, ruleBob), identity: alice, want: ruleNoMatch},
+		{name: "require conditional", include: rules(ruleAlice), require: rules(ruleIP), identity: alice, want: ruleConditional},
+		{name: "require not met wins over conditional", include: rules(ruleAlice), require: rules(ruleIP, ruleBob), identity: alice, want: ruleNoMatch},
+		{name: "exclude match", include: rules(ruleEveryone), exclude: rules(ruleAlice), identity: alice, want: ruleNoMatch},
+		{name: "exclude no match", include: rules(ruleEveryone), exclude: rules(ruleBob), identity: alice, want: ruleMatch},
+		{name: "exclude conditional", include: rules(ruleEveryone), exclude: rules(ruleIP), identity: alice, want: ruleConditional},
+		{name: "exclude match wins over conditional include", include: rules(ruleIP), exclude: rules(ruleAlice), identity: alice, want: ruleNoMatch},
+		{name: "unknown rule is conditional", include: rules(ruleUnknown), identity: alice, want: ruleConditional},
+		{name: "unknown required rule is conditional", include: rules(ruleAlice), require: rules(ruleUnknown), identity: alice, want: ruleConditional},
+		{name: "unknown excluded rule is conditional", include: rules(ruleAlice), exclude: rules(ruleUnknown), identity: alice, want: ruleConditional},
+		{name: "everyone excludes service tokens", include: rules(ruleEveryone), identity: tokenIdentity, want: ruleNoMatch},
+		{name: "service token", include: rules(ruleToken), identity: tokenIdentity, want: ruleMatch},
+		{name: "other service token", include: rules(ruleToken), identity: otherTokenIdenty, want: ruleNoMatch},
+		{name: "service token rule excludes users", include: rules(ruleToken, ruleAnyToken), identity: alice, want: ruleNoMatch},
+		{name: "any valid service token", include: rules(ruleAnyToken), identity: otherTokenIdenty, want: ruleMatch},
+	}
+
+	e := newTestEvaluator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _, _ := e.evaluateRules(tt.include, tt.require, tt.exclude, tt.identity, make(map[string]bool))
+			if got != tt.want {
+				t.Errorf("evaluateRules() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	allowAlice := cloudflare.AccessPolicy{ID: "allow-alice", Decision: policyDecisionAllow, Precedence: 2, Include: rules(ruleAlice)}
+	allowOffice := cloudflare.AccessPolicy{ID: "allow-office", Decision: policyDecisionAllow, Precedence: 2, Include: rules(ruleIP)}
+	denyAlice := cloudflare.AccessPolicy{ID: "deny-alice", Decision: policyDecisionDeny, Precedence: 1, Include: rules(ruleAlice)}
+	denyOffice := cloudflare.AccessPolicy{ID: "deny-office", Decision: policyDecisionDeny, Precedence: 1, Include: rules(ruleIP)}
+	allowToken := cloudflare.AccessPolicy{ID: "allow-token", Decision: policyDecisionAllow, Precedence: 1, Include: rules(ruleToken)}
+	serviceAuthToken := cloudflare.AccessPolicy{ID: "service-auth-token", Decision: policyDecisionNonIdentity, Precedence: 2, Include: rules(ruleToken)}
+
+	tests := []struct {
+		name            string
+		policies        []cloudflare.AccessPolicy
+		identity        accessIdentity
+		wantAllowed     bool
+		wantConditional bool
+		wantPolicyID    string
+	}{
+		{name: "no policies", identity: alice},
+		{name: "allowed", policies: []cloudflare.AccessPolicy{allowAlice}, identity: alice, wantAllowed: true, wantPolicyID: "allow-alice"},
+		{name: "not included", policies: []cloudflare.AccessPolicy{allowAlice}, identity: carol},
+		{name: "deny takes precedence", policies: []cloudflare.AccessPolicy{allowAlice, denyAlice}, identity: alice, wantPolicyID: "deny-alice"},
+		{
+			name:            "conditional deny before allow",
+			policies:        []cloudflare.AccessPolicy{allowAlice, denyOffice},
+			identity:        alice,
+			wantAllowed:     true,
+			wantConditional: true,
+			wantPolicyID:    "allow-alice",
+		},
+		{
+			name:            "conditional allow",
+			policies:        []cloudflare.AccessPolicy{allowOffice},
+			identity:        alice,
+			wantAllowed:     true,
+			wantConditional: true,
+			wantPolicyID:    "allow-office",
+		},
+		{name: "allow policies don't admit service tokens", policies: []cloudflare.AccessPolicy{allowToken}, identity: tokenIdentity},
+		{
+			name:         "service auth admits service tokens",
+			policies:     []cloudflare.AccessPolicy{allowToken, serviceAuthToken},
+			identity:     tokenIdentity,
+			wantAllowed:  true,
+			wantPolicyID: "service-auth-token",
+		},
+	}
+
+	e := newTestEvaluator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.evaluate(tt.policies, tt.identity)
+			if got.Allowed != tt.wantAllowed || got.Conditional != tt.wantConditional || got.PolicyID != tt.wantPolicyID {
+				t.Errorf(
+					"evaluate() = allowed %t, conditional %t, policy %q, want allowed %t, conditional %t, policy %q",
+					got.Allowed, got.Conditional, got.PolicyID, tt.wantAllowed, tt.wantConditional, tt.wantPolicyID,
+				)
+			}
+		})
+	}
+}
+
+func TestEvaluateConditions(t *testing.T) {
+	policies := []cloudflare.AccessPolicy{
+		{ID: "allow", Decision: policyDecisionAllow, Include: rules(ruleEngineering), Require: rules(ruleIP, ruleUnknown)},
+	}
+
+	got := newTestEvaluator().evaluate(policies, alice)
+	if len(got.Conditions) != 2 || got.Conditions[0] != "future_rule" || got.Conditions[1] != "ip" {
+		t.Errorf("evaluate() conditions = %v, want [future_rule ip]", got.Conditions)
+	}
+}
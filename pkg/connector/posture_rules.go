@@ -0,0 +1,140 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+type postureRuleBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (p *postureRuleBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return p.resourceType
+}
+
+// postureRuleIDs returns the device posture rules referenced by Access rules, in order and without duplicates.
+func postureRuleIDs(rules ...[]interface{}) []string {
+	var (
+		rv   []string
+		seen = make(map[string]bool)
+	)
+	for _, ruleSet := range rules {
+		for _, rule := range ruleSet {
+			ruleType, value := parseAccessRule(rule)
+			if ruleType != "device_posture" || value == "" || seen[value] {
+				continue
+			}
+			seen[value] = true
+			rv = append(rv, value)
+		}
+	}
+
+	return rv
+}
+
+// newPostureRuleResource creates a new connector resource for a device posture rule. requiredBy are the
+// names of the Access groups whose rules reference it.
+func newPostureRuleResource(rule cloudflare.DevicePostureRule, integration *cloudflare.DevicePostureIntegration, requiredBy []string) (*v2.Resource, error) {
+	platforms := make([]string, 0, len(rule.Match))
+	for _, match := range rule.Match {
+		platforms = append(platforms, match.Platform)
+	}
+
+	input, err := json.Marshal(rule.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := map[string]interface{}{
+		"rule_id":            rule.ID,
+		"rule_name":          rule.Name,
+		"rule_type":          rule.Type,
+		"description":        rule.Description,
+		"schedule":           rule.Schedule,
+		"expiration":         rule.Expiration,
+		"platforms":          stringsProfileValue(platforms),
+		"input":              string(input),
+		"required_by_groups": stringsProfileValue(requiredBy),
+	}
+	if integration != nil {
+		profile["integration_id"] = integration.IntegrationID
+		profile["integration_name"] = integration.Name
+		profile["integration_type"] = integration.Type
+	}
+
+	return rs.NewGroupResource(
+		rule.Name,
+		postureRuleResourceType,
+		rule.ID,
+		[]rs.GroupTraitOption{rs.WithGroupProfile(profile)},
+		rs.WithDescription(rule.Description),
+	)
+}
+
+// List returns the device posture rules of the account, with the integration they are evaluated by and
+// the Access groups that require them.
+func (p *postureRuleBuilder) List(ctx context.Context, _ *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	rules, _, err := p.client.DevicePostureRules(ctx, p.accountId)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list device posture rules")
+	}
+
+	integrations, _, err := p.client.DevicePostureIntegrations(ctx, p.accountId)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list device posture integrations")
+	}
+	integrationsByID := make(map[string]*cloudflare.DevicePostureIntegration, len(integrations))
+	for i := range integrations {
+		integrationsByID[integrations[i].IntegrationID] = &integrations[i]
+	}
+
+	groups, _, err := p.client.ListAccessGroups(ctx, cloudflare.AccountIdentifier(p.accountId), cloudflare.ListAccessGroupsParams{})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access groups")
+	}
+	requiredBy := make(map[string][]string)
+	for _, group := range groups {
+		for _, id := range postureRuleIDs(group.Include, group.Require, group.Exclude) {
+			requiredBy[id] = append(requiredBy[id], group.Name)
+		}
+	}
+
+	resources := make([]*v2.Resource, 0, len(rules))
+	for _, rule := range rules {
+		resource, err := newPostureRuleResource(rule, integrationsByID[rule.Input.ConnectionID], requiredBy[rule.ID])
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create posture rule resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+// Entitlements always returns an empty slice for posture rules.
+func (p *postureRuleBuilder) Entitlements(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for posture rules since they don't have any entitlements.
+func (p *postureRuleBuilder) Grants(_ context.Context, _ *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func newPostureRuleBuilder(client *cloudflare.API, accountId string) *postureRuleBuilder {
+	return &postureRuleBuilder{
+		resourceType: postureRuleResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}
@@ -0,0 +1,330 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// Cloudflare allows 1200 requests per 5 minutes to every user. The bucket refills so that a full burst
+	// and the requests of the rest of the window stay under the limit.
+	rateLimitRequests = 1200
+	rateLimitWindow   = 5 * time.Minute
+	rateLimitBurst    = 60
+
+	rateLimitMaxRetries    = 5
+	rateLimitMinRetryDelay = time.Second
+	rateLimitMaxRetryDelay = time.Minute
+)
+
+// rateLimitTransport throttles every request to the Cloudflare API with a token bucket shared by the
+// connector. Rate limited requests are retried once their Retry-After has passed, during which every
+// other request waits too, and idempotent requests failing with a 5xx are retried with an exponential backoff.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+func newRateLimitTransport(base http.RoundTripper) *rateLimitTransport {
+	refill := rate.Limit(float64(rateLimitRequests-rateLimitBurst) / rateLimitWindow.Seconds())
+
+	return &rateLimitTransport{
+		base:    base,
+		limiter: rate.NewLimiter(refill, rateLimitBurst),
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	l := ctxzap.Extract(ctx)
+
+	for attempt := 0; ; attempt++ {
+		err := t.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		delay, ok := t.retryDelay(req, resp, attempt)
+		if !ok {
+			return resp, nil
+		}
+
+		l.Warn(
+			"baton-cloudflare-zero-trust: retrying cloudflare request",
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.Int("status", resp.StatusCode),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay),
+		)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		err = sleepContext(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait blocks until a request can be sent: the Retry-After of the last rate limited request has passed
+// and a token is available.
+func (t *rateLimitTransport) wait(ctx context.Context) error {
+	t.mu.Lock()
+	blocked := time.Until(t.blockedUntil)
+	t.mu.Unlock()
+
+	if blocked > 0 {
+		err := sleepContext(ctx, blocked)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.limiter.Wait(ctx)
+}
+
+// retryDelay returns how long to wait before retrying the request, and false when it must not be retried.
+func (t *rateLimitTransport) retryDelay(req *http.Request, resp *http.Response, attempt int) (time.Duration, bool) {
+	if attempt >= rateLimitMaxRetries || (req.Body != nil && req.GetBody == nil) {
+		return 0, false
+	}
+
+	delay, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		delay = time.Duration(math.Min(
+			float64(rateLimitMinRetryDelay)*math.Pow(2, float64(attempt)),
+			float64(rateLimitMaxRetryDelay),
+		))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		t.mu.Lock()
+		if until := time.Now().Add(delay); until.After(t.blockedUntil) {
+			t.blockedUntil = until
+		}
+		t.mu.Unlock()
+		return delay, true
+	case resp.StatusCode >= http.StatusInternalServerError && isIdempotent(req.Method):
+		return delay, true
+	default:
+		return 0, false
+	}
+}
+
+// description returns the rate limit state of the connector: the bucket size as the limit, the tokens
+// left, and when the bucket is full again or the last Retry-After passes.
+func (t *rateLimitTransport) description() *v2.RateLimitDescription {
+	now := time.Now()
+	tokens := math.Max(0, t.limiter.TokensAt(now))
+	resetAt := now.Add(time.Duration((rateLimitBurst - tokens) / float64(t.limiter.Limit()) * float64(time.Second)))
+	status := v2.RateLimitDescription_STATUS_OK
+
+	t.mu.Lock()
+	if t.blockedUntil.After(now) {
+		status = v2.RateLimitDescription_STATUS_OVERLIMIT
+		resetAt = t.blockedUntil
+	}
+	t.mu.Unlock()
+
+	if tokens < 1 {
+		status = v2.RateLimitDescription_STATUS_OVERLIMIT
+	}
+
+	return &v2.RateLimitDescription{
+		Status:    status,
+		Limit:     rateLimitBurst,
+		Remaining: int64(tokens),
+		ResetAt:   timestamppb.New(resetAt),
+	}
+}
+
+// annotate adds the rate limit state to the annotations of a response.
+func (t *rateLimitTransport) annotate(annos []*anypb.Any) []*anypb.Any {
+	rv := annotations.Annotations(annos)
+	rv.WithRateLimiting(t.description())
+
+	return rv
+}
+
+// statusError returns the error of a request to the connector. Requests that failed because Cloudflare
+// still rate limited them once the retries ran out fail with codes.Unavailable, detailed with the rate
+// limit state, so the caller knows to back off until it resets. Other errors are returned unchanged.
+func (t *rateLimitTransport) statusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// cloudflare-go returns rate limit errors by pointer, but their methods make values errors too.
+	var (
+		rateLimited      *cloudflare.RatelimitError
+		rateLimitedValue cloudflare.RatelimitError
+	)
+	if !errors.As(err, &rateLimited) && !errors.As(err, &rateLimitedValue) {
+		return err
+	}
+
+	st, detailErr := status.New(codes.Unavailable, err.Error()).WithDetails(t.description())
+	if detailErr != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return st.Err()
+}
+
+// retryAfter parses a Retry-After header, either a number of seconds or an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(math.Max(0, float64(seconds))) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now), date.After(now)
+	}
+
+	return 0, false
+}
+
+// isIdempotent reports whether requests of the method can be retried without side effects.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedServer adds the rate limit state of the connector to the annotations of its list responses,
+// so the Baton SDK can pace the sync, and reports requests still rate limited after the retries as
+// unavailable.
+type rateLimitedServer struct {
+	types.ConnectorServer
+	limits *rateLimitTransport
+}
+
+// RateLimitedServer wraps the server built from the connector so its list responses describe the rate
+// limit state of the Cloudflare API.
+func (d *Connector) RateLimitedServer(server types.ConnectorServer) types.ConnectorServer {
+	return &rateLimitedServer{
+		ConnectorServer: server,
+		limits:          d.limits,
+	}
+}
+
+func (s *rateLimitedServer) ListResources(
+	ctx context.Context,
+	req *v2.ResourcesServiceListResourcesRequest,
+) (*v2.ResourcesServiceListResourcesResponse, error) {
+	resp, err := s.ConnectorServer.ListResources(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+	resp.Annotations = s.limits.annotate(resp.Annotations)
+
+	return resp, nil
+}
+
+func (s *rateLimitedServer) ListEntitlements(
+	ctx context.Context,
+	req *v2.EntitlementsServiceListEntitlementsRequest,
+) (*v2.EntitlementsServiceListEntitlementsResponse, error) {
+	resp, err := s.ConnectorServer.ListEntitlements(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+	resp.Annotations = s.limits.annotate(resp.Annotations)
+
+	return resp, nil
+}
+
+func (s *rateLimitedServer) ListGrants(ctx context.Context, req *v2.GrantsServiceListGrantsRequest) (*v2.GrantsServiceListGrantsResponse, error) {
+	resp, err := s.ConnectorServer.ListGrants(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+	resp.Annotations = s.limits.annotate(resp.Annotations)
+
+	return resp, nil
+}
+
+func (s *rateLimitedServer) ListEvents(ctx context.Context, req *v2.ListEventsRequest) (*v2.ListEventsResponse, error) {
+	resp, err := s.ConnectorServer.ListEvents(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+	resp.Annotations = s.limits.annotate(resp.Annotations)
+
+	return resp, nil
+}
+
+func (s *rateLimitedServer) Grant(ctx context.Context, req *v2.GrantManagerServiceGrantRequest) (*v2.GrantManagerServiceGrantResponse, error) {
+	resp, err := s.ConnectorServer.Grant(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+
+	return resp, nil
+}
+
+func (s *rateLimitedServer) Revoke(ctx context.Context, req *v2.GrantManagerServiceRevokeRequest) (*v2.GrantManagerServiceRevokeResponse, error) {
+	resp, err := s.ConnectorServer.Revoke(ctx, req)
+	if err != nil {
+		return nil, s.limits.statusError(err)
+	}
+
+	return resp, nil
+}
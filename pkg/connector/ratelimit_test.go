@@ -0,0 +1,205 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeRoundTripper returns its responses in order, repeating the last one, and records the requests and
+// their bodies.
+type fakeRoundTripper struct {
+	responses []func() *http.Response
+	requests  []*http.Request
+	bodies    []string
+}
+
+func (f *fakeRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(data)
+	}
+	f.requests = append(f.requests, req)
+	f.bodies = append(f.bodies, body)
+
+	i := len(f.requests) - 1
+	if i >= len(f.responses) {
+		i = len(f.responses) - 1
+	}
+
+	return f.responses[i](), nil
+}
+
+func response(statusCode int, retryAfter string) func() *http.Response {
+	return func() *http.Response {
+		header := http.Header{}
+		if retryAfter != "" {
+			header.Set("Retry-After", retryAfter)
+		}
+
+		return &http.Response{
+			StatusCode: statusCode,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader("{}")),
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "missing", value: ""},
+		{name: "seconds", value: "3", want: 3 * time.Second, wantOk: true},
+		{name: "negative seconds", value: "-1", want: 0, wantOk: true},
+		{name: "future date", value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, wantOk: true},
+		{name: "past date", value: now.Add(-time.Minute).Format(http.TimeFormat), want: -time.Minute},
+		{name: "invalid", value: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.value, now)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("retryAfter(%q) = %s, %t, want %s, %t", tt.value, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "https://api.cloudflare.com/client/v4/accounts", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://api.cloudflare.com/client/v4/accounts", strings.NewReader("{}"))
+	unreplayable, _ := http.NewRequest(http.MethodPut, "https://api.cloudflare.com/client/v4/accounts", strings.NewReader("{}"))
+	unreplayable.GetBody = nil
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		resp      func() *http.Response
+		attempt   int
+		want      time.Duration
+		wantRetry bool
+	}{
+		{name: "rate limited", req: post, resp: response(http.StatusTooManyRequests, "2"), want: 2 * time.Second, wantRetry: true},
+		{name: "rate limited without retry-after", req: get, resp: response(http.StatusTooManyRequests, ""), attempt: 2, want: 4 * time.Second, wantRetry: true},
+		{name: "server error", req: get, resp: response(http.StatusServiceUnavailable, ""), want: time.Second, wantRetry: true},
+		{name: "server error backs off", req: get, resp: response(http.StatusBadGateway, ""), attempt: 3, want: 8 * time.Second, wantRetry: true},
+		{name: "server error of a post", req: post, resp: response(http.StatusInternalServerError, "")},
+		{name: "client error", req: get, resp: response(http.StatusBadRequest, "")},
+		{name: "success", req: get, resp: response(http.StatusOK, "")},
+		{name: "retries exhausted", req: get, resp: response(http.StatusTooManyRequests, "1"), attempt: rateLimitMaxRetries},
+		{name: "body can't be replayed", req: unreplayable, resp: response(http.StatusTooManyRequests, "1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := newRateLimitTransport(&fakeRoundTripper{})
+			got, retry := transport.retryDelay(tt.req, tt.resp(), tt.attempt)
+			if retry != tt.wantRetry || got != tt.want {
+				t.Errorf("retryDelay() = %s, %t, want %s, %t", got, retry, tt.want, tt.wantRetry)
+			}
+
+			blocked := transport.description().Status == v2.RateLimitDescription_STATUS_OVERLIMIT
+			if wantBlocked := retry && tt.resp().StatusCode == http.StatusTooManyRequests; blocked != wantBlocked {
+				t.Errorf("rate limit overlimit = %t, want %t", blocked, wantBlocked)
+			}
+		})
+	}
+}
+
+func TestRoundTripReplaysBody(t *testing.T) {
+	base := &fakeRoundTripper{responses: []func() *http.Response{
+		response(http.StatusTooManyRequests, "0"),
+		response(http.StatusOK, ""),
+	}}
+	transport := newRateLimitTransport(base)
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.cloudflare.com/client/v4/accounts", strings.NewReader(`{"name":"x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if len(base.bodies) != 2 || base.bodies[0] != `{"name":"x"}` || base.bodies[1] != `{"name":"x"}` {
+		t.Errorf("request bodies = %q, want the body sent twice", base.bodies)
+	}
+}
+
+func TestRoundTripGivesUp(t *testing.T) {
+	base := &fakeRoundTripper{responses: []func() *http.Response{response(http.StatusTooManyRequests, "0")}}
+	transport := newRateLimitTransport(base)
+
+	req, err := http.NewRequest(http.MethodGet, "https://api.cloudflare.com/client/v4/accounts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if len(base.requests) != rateLimitMaxRetries+1 {
+		t.Errorf("requests sent = %d, want %d", len(base.requests), rateLimitMaxRetries+1)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	transport := newRateLimitTransport(&fakeRoundTripper{})
+	rateLimited := cloudflare.NewRatelimitError(&cloudflare.Error{StatusCode: http.StatusTooManyRequests})
+
+	for _, err := range []error{
+		fmt.Errorf("listing grants failed: %w", &rateLimited),
+		fmt.Errorf("listing grants failed: %w", rateLimited),
+	} {
+		st, ok := status.FromError(transport.statusError(err))
+		if !ok || st.Code() != codes.Unavailable {
+			t.Fatalf("statusError() = %v, want an unavailable status", st)
+		}
+
+		var description *v2.RateLimitDescription
+		for _, detail := range st.Details() {
+			if d, ok := detail.(*v2.RateLimitDescription); ok {
+				description = d
+			}
+		}
+		if description == nil || description.Limit != rateLimitBurst {
+			t.Errorf("statusError() details = %v, want the rate limit description", st.Details())
+		}
+	}
+
+	other := errors.New("not found")
+	if got := transport.statusError(other); got != other {
+		t.Errorf("statusError() = %v, want the error unchanged", got)
+	}
+	if got := transport.statusError(nil); got != nil {
+		t.Errorf("statusError(nil) = %v, want nil", got)
+	}
+}
@@ -28,4 +28,64 @@ var (
 		DisplayName: "Member",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_USER},
 	}
+	applicationResourceType = &v2.ResourceType{
+		Id:          "application",
+		DisplayName: "Application",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
+	policyResourceType = &v2.ResourceType{
+		Id:          "policy",
+		DisplayName: "Policy",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	serviceTokenResourceType = &v2.ResourceType{
+		Id:          "service_token",
+		DisplayName: "Service Token",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_USER},
+		Annotations: annotationsForUserResourceType(),
+	}
+	tagResourceType = &v2.ResourceType{
+		Id:          "tag",
+		DisplayName: "Tag",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	apiTokenResourceType = &v2.ResourceType{
+		Id:          "api_token",
+		DisplayName: "API Token",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_USER},
+		Annotations: annotationsForUserResourceType(),
+	}
+	accountResourceType = &v2.ResourceType{
+		Id:          "account",
+		DisplayName: "Account",
+	}
+	zoneResourceType = &v2.ResourceType{
+		Id:          "zone",
+		DisplayName: "Zone",
+	}
+	idpGroupResourceType = &v2.ResourceType{
+		Id:          "idp_group",
+		DisplayName: "IdP Group",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	deviceResourceType = &v2.ResourceType{
+		Id:          "device",
+		DisplayName: "Device",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
+	postureRuleResourceType = &v2.ResourceType{
+		Id:          "posture_rule",
+		DisplayName: "Device Posture Rule",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+		Annotations: annotationsForUserResourceType(),
+	}
+	identityProviderResourceType = &v2.ResourceType{
+		Id:          "identity_provider",
+		DisplayName: "Identity Provider",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+	seatResourceType = &v2.ResourceType{
+		Id:          "seat",
+		DisplayName: "Seat",
+	}
 )
@@ -0,0 +1,66 @@
+package connector
+
+import (
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Risk flags recorded in the profile of groups, policies and applications that open access
+// to every IdP user or bypass identity checks altogether.
+const (
+	riskEveryone    = "everyone"
+	riskBypass      = "bypass"
+	riskNonIdentity = "non_identity"
+)
+
+// riskFailedLogins is recorded in the profile of users whose recent failed logins reach the threshold.
+const riskFailedLogins = "failed_logins"
+
+// riskOneTimePin is recorded in the profile of users who last authenticated with a one-time PIN instead of an IdP.
+const riskOneTimePin = "one_time_pin"
+
+// hasEveryoneRule reports whether one of the rules is an "everyone" rule.
+func hasEveryoneRule(rules []interface{}) bool {
+	for _, rule := range rules {
+		if ruleType, _ := parseAccessRule(rule); ruleType == "everyone" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func groupRiskFlags(group *cloudflare.AccessGroup) []string {
+	if hasEveryoneRule(group.Include) {
+		return []string{riskEveryone}
+	}
+
+	return nil
+}
+
+func policyRiskFlags(policy cloudflare.AccessPolicy) []string {
+	var flags []string
+	switch policy.Decision {
+	case policyDecisionBypass:
+		flags = append(flags, riskBypass)
+	case policyDecisionNonIdentity:
+		flags = append(flags, riskNonIdentity)
+	}
+
+	if policy.Decision != policyDecisionDeny && hasEveryoneRule(policy.Include) {
+		flags = append(flags, riskEveryone)
+	}
+
+	return flags
+}
+
+// applicationRiskFlags returns the union of the risk flags of the policies of an application.
+func applicationRiskFlags(policies []cloudflare.AccessPolicy) []string {
+	flags := make(map[string]bool)
+	for _, policy := range policies {
+		for _, flag := range policyRiskFlags(policy) {
+			flags[flag] = true
+		}
+	}
+
+	return sortedKeys(flags)
+}
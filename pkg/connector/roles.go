@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/cloudflare/cloudflare-go"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -23,6 +24,8 @@ type roleBuilder struct {
 	client       *cloudflare.API
 	accountId    string
 	httpClient   *http.Client
+	// changes carries role assignments forward from the previous sync when incremental sync is enabled.
+	changes *changeTracker
 }
 
 const errMissingAccountID = "required missing account ID"
@@ -135,6 +138,18 @@ func (r *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, token *
 		return nil, "", nil, err
 	}
 
+	// Role assignments are only changed through account members, and the ETag is checked on the first page.
+	var annos annotations.Annotations
+	if token.Token == "" {
+		entitlementID := ent.NewEntitlementID(resource, resource.DisplayName)
+		since := r.changes.watermark(ctx)
+		matchAnnos, ok := r.changes.carryForward(ctx, resource, entitlementID, "", auditResourceAccountMember)
+		if ok {
+			return nil, "", matchAnnos, nil
+		}
+		annos = r.changes.etag(entitlementID, since, time.Time{})
+	}
+
 	members, info, err := r.client.AccountMembers(ctx, r.accountId, cloudflare.PaginationOptions{
 		Page:    page,
 		PerPage: resourcePageSize,
@@ -168,7 +183,7 @@ func (r *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, token *
 	}
 
 	if info.TotalPages <= info.Page {
-		return rv, "", nil, nil
+		return rv, "", annos, nil
 	}
 
 	nextPage, err := getPageTokenFromPage(bag, page+1)
@@ -176,7 +191,7 @@ func (r *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, token *
 		return nil, "", nil, err
 	}
 
-	return rv, nextPage, nil, nil
+	return rv, nextPage, annos, nil
 }
 
 // GetAccountMember returns an account member.
@@ -185,7 +200,6 @@ func (r *roleBuilder) GetAccountMember(ctx context.Context, accountID string, me
 	if accountID == "" {
 		return &cloudflare.AccountMemberDetailResponse{}, ErrMissingAccountID
 	}
-	r.httpClient = &http.Client{}
 	requestURL := fmt.Sprintf("%s/accounts/%s/members/%s", r.client.BaseURL, accountID, memberID)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
 	if err != nil {
@@ -325,10 +339,12 @@ func (r *roleBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.
 	return nil, nil
 }
 
-func newRoleBuilder(client *cloudflare.API, accountId string) *roleBuilder {
+func newRoleBuilder(client *cloudflare.API, accountId string, httpClient *http.Client, changes *changeTracker) *roleBuilder {
 	return &roleBuilder{
 		resourceType: roleResourceType,
 		client:       client,
 		accountId:    accountId,
+		httpClient:   httpClient,
+		changes:      changes,
 	}
 }
@@ -0,0 +1,184 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// SeatReclamation is an Access user holding seats without any activity for longer than the threshold.
+type SeatReclamation struct {
+	UserID       string `json:"user_id"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	SeatUID      string `json:"seat_uid"`
+	AccessSeat   bool   `json:"access_seat"`
+	GatewaySeat  bool   `json:"gateway_seat"`
+	LastActivity string `json:"last_activity,omitempty"`
+	InactiveDays int    `json:"inactive_days"`
+}
+
+// seatAuditEntry records a seat change made during reclamation.
+type seatAuditEntry struct {
+	Time    string `json:"time"`
+	Account string `json:"account_id"`
+	SeatReclamation
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// parseAccessTime parses the timestamps of Access users, which may or may not carry fractional seconds.
+func parseAccessTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// lastAccessUserActivity returns the latest of the last successful login and the last update of a user,
+// falling back to its creation for users that never logged in.
+func lastAccessUserActivity(user cloudflare.AccessUser) (time.Time, bool) {
+	var (
+		last  time.Time
+		found bool
+	)
+	for _, value := range []string{user.LastSuccessfulLogin, user.UpdatedAt} {
+		if t, ok := parseAccessTime(value); ok && t.After(last) {
+			last = t
+			found = true
+		}
+	}
+	if found {
+		return last, true
+	}
+
+	return parseAccessTime(user.CreatedAt)
+}
+
+// inactiveSeatHolder returns the reclamation of the seats of a user inactive for longer than inactiveAfter.
+func inactiveSeatHolder(user cloudflare.AccessUser, inactiveAfter time.Duration, now time.Time) (SeatReclamation, bool) {
+	accessSeat := user.AccessSeat != nil && *user.AccessSeat
+	gatewaySeat := user.GatewaySeat != nil && *user.GatewaySeat
+	if !accessSeat && !gatewaySeat {
+		return SeatReclamation{}, false
+	}
+
+	rv := SeatReclamation{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Name:        user.Name,
+		SeatUID:     user.SeatUID,
+		AccessSeat:  accessSeat,
+		GatewaySeat: gatewaySeat,
+	}
+
+	last, ok := lastAccessUserActivity(user)
+	if !ok {
+		// Users without any activity are always reclaimed.
+		return rv, true
+	}
+
+	inactive := now.Sub(last)
+	if inactive <= inactiveAfter {
+		return SeatReclamation{}, false
+	}
+
+	rv.LastActivity = last.Format(time.RFC3339)
+	rv.InactiveDays = int(inactive.Hours() / 24)
+
+	return rv, true
+}
+
+// SeatReclamationPlan returns the Access users holding seats that have been inactive for longer than inactiveAfter.
+func (d *Connector) SeatReclamationPlan(ctx context.Context, inactiveAfter time.Duration) ([]SeatReclamation, error) {
+	users, _, err := d.client.ListAccessUsers(ctx, cloudflare.AccountIdentifier(d.accountId), cloudflare.AccessUserParams{})
+	if err != nil {
+		return nil, wrapError(err, "failed to list users")
+	}
+
+	var rv []SeatReclamation
+	now := time.Now()
+	for _, user := range users {
+		if reclamation, ok := inactiveSeatHolder(user, inactiveAfter, now); ok {
+			rv = append(rv, reclamation)
+		}
+	}
+
+	return rv, nil
+}
+
+// ReclaimSeats removes the Access and Gateway seats of every user of the plan and writes a JSON line per
+// user to the audit log. It carries on after a failure and returns an error listing the failed users.
+func (d *Connector) ReclaimSeats(ctx context.Context, plan []SeatReclamation, audit io.Writer) error {
+	l := ctxzap.Extract(ctx)
+
+	enc := json.NewEncoder(audit)
+	var failed []string
+	for _, reclamation := range plan {
+		entry := seatAuditEntry{
+			Account:         d.accountId,
+			SeatReclamation: reclamation,
+			Result:          "reclaimed",
+		}
+
+		err := setAccessUserSeats(ctx, d.client, d.accountId, reclamation.SeatUID, false, false)
+		if err != nil {
+			entry.Result = "failed"
+			entry.Error = err.Error()
+			failed = append(failed, reclamation.Email)
+			l.Error("baton-cloudflare-zero-trust: failed to reclaim seats", zap.String("user_id", reclamation.UserID), zap.Error(err))
+		} else {
+			l.Info("baton-cloudflare-zero-trust: seats reclaimed", zap.String("user_id", reclamation.UserID), zap.String("email", reclamation.Email))
+		}
+
+		entry.Time = time.Now().UTC().Format(time.RFC3339)
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("baton-cloudflare-zero-trust: failed to write seat audit log: %w", err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("baton-cloudflare-zero-trust: failed to reclaim the seats of %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// WriteSeatReclamationPlan writes a human readable reclamation plan.
+func WriteSeatReclamationPlan(w io.Writer, plan []SeatReclamation, inactiveAfter time.Duration) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d users holding seats inactive for more than %s\n", len(plan), inactiveAfter)
+	for _, reclamation := range plan {
+		var seats []string
+		if reclamation.AccessSeat {
+			seats = append(seats, accessSeatEntitlement)
+		}
+		if reclamation.GatewaySeat {
+			seats = append(seats, gatewaySeatEntitlement)
+		}
+
+		lastActivity := "never"
+		if reclamation.LastActivity != "" {
+			lastActivity = fmt.Sprintf("%s (%d days ago)", reclamation.LastActivity, reclamation.InactiveDays)
+		}
+
+		fmt.Fprintf(&b, "- %s (%s): remove %s, last activity %s\n", reclamation.Email, reclamation.UserID, strings.Join(seats, ", "), lastActivity)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,246 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// Billable Zero Trust seats a user can hold.
+const (
+	accessSeatEntitlement  = "access_seat"
+	gatewaySeatEntitlement = "gateway_seat"
+)
+
+type seatBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	// inactiveAfter enables the inactive seat report when set.
+	inactiveAfter time.Duration
+	// revokeDevices revokes the WARP devices of users whose last seat is revoked.
+	revokeDevices bool
+}
+
+func (s *seatBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return s.resourceType
+}
+
+// newSeatResource creates the resource holding the Zero Trust seats of the account.
+func newSeatResource(accountId string) (*v2.Resource, error) {
+	return rs.NewResource(
+		"Zero Trust Seats",
+		seatResourceType,
+		accountId,
+		rs.WithDescription("Billable Access and Gateway seats of the Zero Trust organization"),
+	)
+}
+
+// List returns the single resource holding the Zero Trust seats of the account.
+func (s *seatBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	resource, err := newSeatResource(s.accountId)
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to create seat resource")
+	}
+
+	return []*v2.Resource{resource}, "", nil, nil
+}
+
+// Entitlements returns the Access and Gateway seat entitlements.
+func (s *seatBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	rv := make([]*v2.Entitlement, 0, 2)
+	for _, seat := range []struct {
+		name        string
+		displayName string
+	}{
+		{accessSeatEntitlement, "Access seat"},
+		{gatewaySeatEntitlement, "Gateway seat"},
+	} {
+		options := []ent.EntitlementOption{
+			ent.WithGrantableTo(userResourceType),
+			ent.WithDisplayName(seat.displayName),
+			ent.WithDescription(fmt.Sprintf("Billable Cloudflare Zero Trust %s", seat.displayName)),
+		}
+
+		rv = append(rv, ent.NewAssignmentEntitlement(resource, seat.name, options...))
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grants returns a grant for every seat held by an Access user. When the inactive seat report is enabled,
+// seats held by users inactive for too long are logged and flagged in the grant metadata.
+func (s *seatBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: s.resourceType.Id})
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users, info, err := s.client.ListAccessUsers(ctx, cloudflare.AccountIdentifier(s.accountId), cloudflare.AccessUserParams{
+		ResultInfo: cloudflare.ResultInfo{
+			Page:    page,
+			PerPage: resourcePageSize,
+		},
+	})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list users")
+	}
+
+	var rv []*v2.Grant
+	now := time.Now()
+	for _, user := range users {
+		ur, err := newUserResource(user)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create user resource")
+		}
+
+		var grantOptions []grant.GrantOption
+		if s.inactiveAfter > 0 {
+			if reclamation, ok := inactiveSeatHolder(user, s.inactiveAfter, now); ok {
+				l.Info(
+					"baton-cloudflare-zero-trust: inactive user holds seats",
+					zap.String("user_id", user.ID),
+					zap.String("email", user.Email),
+					zap.String("last_activity", reclamation.LastActivity),
+					zap.Int("inactive_days", reclamation.InactiveDays),
+				)
+				grantOptions = append(grantOptions, grant.WithGrantMetadata(map[string]interface{}{
+					"inactive":      true,
+					"last_activity": reclamation.LastActivity,
+					"inactive_days": reclamation.InactiveDays,
+				}))
+			}
+		}
+
+		if user.AccessSeat != nil && *user.AccessSeat {
+			rv = append(rv, grant.NewGrant(resource, accessSeatEntitlement, ur.Id, grantOptions...))
+		}
+		if user.GatewaySeat != nil && *user.GatewaySeat {
+			rv = append(rv, grant.NewGrant(resource, gatewaySeatEntitlement, ur.Id, grantOptions...))
+		}
+	}
+
+	if info.TotalPages <= info.Page {
+		return rv, "", nil, nil
+	}
+
+	nextPage, err := getPageTokenFromPage(bag, info.Page+1)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, nextPage, nil, nil
+}
+
+func (s *seatBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-cloudflare-zero-trust: only users can be granted seats",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: only users can be granted seats")
+	}
+
+	_, err := updateAccessUserSeat(ctx, s.client, s.accountId, principal.Id.Resource, entitlement.Slug, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (s *seatBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+	principal := grant.Principal
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Warn(
+			"baton-cloudflare-zero-trust: only users can have seats revoked",
+			zap.String("principal_type", principal.Id.ResourceType),
+			zap.String("principal_id", principal.Id.Resource),
+		)
+		return nil, fmt.Errorf("baton-cloudflare-zero-trust: only users can have seats revoked")
+	}
+
+	holdsSeat, err := updateAccessUserSeat(ctx, s.client, s.accountId, principal.Id.Resource, grant.Entitlement.Slug, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// A user left without any seat is offboarded, their devices can't be used anymore.
+	if s.revokeDevices && !holdsSeat {
+		_, err = revokeUserDevices(ctx, s.client, s.accountId, principal.Id.Resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// updateAccessUserSeat assigns or removes a seat of an Access user, keeping the other seat as it is, and
+// returns whether the user still holds a seat.
+func updateAccessUserSeat(ctx context.Context, client *cloudflare.API, accountId string, userID string, seat string, assigned bool) (bool, error) {
+	if seat != accessSeatEntitlement && seat != gatewaySeatEntitlement {
+		return false, fmt.Errorf("baton-cloudflare-zero-trust: unknown seat %q", seat)
+	}
+
+	user, err := findAccessUser(ctx, client, accountId, userID)
+	if err != nil {
+		return false, err
+	}
+
+	accessSeat := user.AccessSeat != nil && *user.AccessSeat
+	gatewaySeat := user.GatewaySeat != nil && *user.GatewaySeat
+	if seat == accessSeatEntitlement {
+		accessSeat = assigned
+	} else {
+		gatewaySeat = assigned
+	}
+
+	err = setAccessUserSeats(ctx, client, accountId, user.SeatUID, accessSeat, gatewaySeat)
+	if err != nil {
+		return false, err
+	}
+
+	return accessSeat || gatewaySeat, nil
+}
+
+// setAccessUserSeats sets both seats of an Access user.
+func setAccessUserSeats(ctx context.Context, client *cloudflare.API, accountId string, seatUID string, accessSeat bool, gatewaySeat bool) error {
+	_, err := client.UpdateAccessUserSeat(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.UpdateAccessUserSeatParams{
+		SeatUID:     seatUID,
+		AccessSeat:  &accessSeat,
+		GatewaySeat: &gatewaySeat,
+	})
+	if err != nil {
+		return wrapError(err, "failed to update access user seat")
+	}
+
+	return nil
+}
+
+func newSeatBuilder(client *cloudflare.API, accountId string, inactiveAfter time.Duration, revokeDevices bool) *seatBuilder {
+	return &seatBuilder{
+		resourceType:  seatResourceType,
+		client:        client,
+		accountId:     accountId,
+		inactiveAfter: inactiveAfter,
+		revokeDevices: revokeDevices,
+	}
+}
@@ -0,0 +1,37 @@
+package connector
+
+import (
+	"context"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/types"
+)
+
+// syncServer drops the per-sync caches of the connector when a sync starts. The syncer lists the
+// resource types before anything else, so the first page of resource types marks a new sync.
+type syncServer struct {
+	types.ConnectorServer
+	connector *Connector
+}
+
+func (s *syncServer) ListResourceTypes(
+	ctx context.Context,
+	req *v2.ResourceTypesServiceListResourceTypesRequest,
+) (*v2.ResourceTypesServiceListResourceTypesResponse, error) {
+	if req.PageToken == "" {
+		s.connector.caches.reset()
+	}
+
+	return s.ConnectorServer.ListResourceTypes(ctx, req)
+}
+
+// NewServer returns the Baton server of the connector.
+func NewServer(ctx context.Context, c *Connector) (types.ConnectorServer, error) {
+	server, err := connectorbuilder.NewConnector(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.RateLimitedServer(&syncServer{ConnectorServer: server, connector: c}), nil
+}
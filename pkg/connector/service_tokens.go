@@ -0,0 +1,328 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Expiry statuses of a service token.
+const (
+	serviceTokenActive   = "active"
+	serviceTokenExpiring = "expiring"
+	serviceTokenExpired  = "expired"
+)
+
+// serviceTokenConfig holds the settings applied to the service tokens managed by the connector.
+type serviceTokenConfig struct {
+	// duration of the service tokens created by the connector. Cloudflare picks the default when empty.
+	duration string
+	// groupID of the Access group that service tokens created by the connector are added to.
+	groupID string
+	// expiryWarning is how long before their expiry service tokens are reported as expiring.
+	expiryWarning time.Duration
+}
+
+type serviceTokenBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+	config       serviceTokenConfig
+}
+
+func (s *serviceTokenBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return s.resourceType
+}
+
+// serviceTokenExpiry returns the expiry status of a service token and its remaining lifetime, which is
+// negative once the token has expired. Tokens without an expiry are always active.
+func serviceTokenExpiry(token cloudflare.AccessServiceToken, expiryWarning time.Duration, now time.Time) (string, time.Duration) {
+	if token.ExpiresAt == nil {
+		return serviceTokenActive, 0
+	}
+
+	remaining := token.ExpiresAt.Sub(now)
+	switch {
+	case remaining <= 0:
+		return serviceTokenExpired, remaining
+	case remaining <= expiryWarning:
+		return serviceTokenExpiring, remaining
+	default:
+		return serviceTokenActive, remaining
+	}
+}
+
+// newServiceTokenResource creates a new connector resource for a Cloudflare Access service token.
+// Service tokens are machine identities, so they carry a user trait with a service account type.
+// Expired tokens are disabled, and tokens expiring within expiryWarning are described as such.
+func newServiceTokenResource(token cloudflare.AccessServiceToken, expiryWarning time.Duration) (*v2.Resource, error) {
+	expiryStatus, remaining := serviceTokenExpiry(token, expiryWarning, time.Now())
+
+	profile := map[string]interface{}{
+		"service_token_id": token.ID,
+		"name":             token.Name,
+		"client_id":        token.ClientID,
+		"duration":         token.Duration,
+		"expiry_status":    expiryStatus,
+	}
+	if token.ExpiresAt != nil {
+		profile["expires_at"] = token.ExpiresAt.Format(time.RFC3339)
+		profile["remaining_lifetime_seconds"] = int64(remaining.Seconds())
+	}
+	if token.UpdatedAt != nil {
+		profile["updated_at"] = token.UpdatedAt.Format(time.RFC3339)
+	}
+
+	userTraits := []rs.UserTraitOption{
+		rs.WithUserProfile(profile),
+		rs.WithAccountType(v2.UserTrait_ACCOUNT_TYPE_SERVICE),
+		rs.WithUserLogin(token.ClientID),
+	}
+
+	var resourceOptions []rs.ResourceOption
+	switch expiryStatus {
+	case serviceTokenExpired:
+		details := fmt.Sprintf("expired at %s", token.ExpiresAt.Format(time.RFC3339))
+		userTraits = append(userTraits, rs.WithDetailedStatus(v2.UserTrait_Status_STATUS_DISABLED, details))
+		resourceOptions = append(resourceOptions, rs.WithDescription(fmt.Sprintf("Service token %s; its group memberships are ineffective", details)))
+	case serviceTokenExpiring:
+		details := fmt.Sprintf("expires at %s", token.ExpiresAt.Format(time.RFC3339))
+		userTraits = append(userTraits, rs.WithDetailedStatus(v2.UserTrait_Status_STATUS_ENABLED, details))
+		resourceOptions = append(resourceOptions, rs.WithDescription(fmt.Sprintf("Service token %s, in %s", details, remaining.Truncate(time.Minute))))
+	default:
+		userTraits = append(userTraits, rs.WithStatus(v2.UserTrait_Status_STATUS_ENABLED))
+	}
+
+	if token.CreatedAt != nil {
+		userTraits = append(userTraits, rs.WithCreatedAt(*token.CreatedAt))
+	}
+
+	ret, err := rs.NewUserResource(token.Name, serviceTokenResourceType, token.ID, userTraits, resourceOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// List returns all the Access service tokens of the account as resource objects.
+func (s *serviceTokenBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	tokens, _, err := s.client.ListAccessServiceTokens(ctx, cloudflare.AccountIdentifier(s.accountId), cloudflare.ListAccessServiceTokensParams{})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access service tokens")
+	}
+
+	resources := make([]*v2.Resource, 0, len(tokens))
+	for _, token := range tokens {
+		resource, err := newServiceTokenResource(token, s.config.expiryWarning)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create service token resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+// Entitlements always returns an empty slice for service tokens.
+func (s *serviceTokenBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for service tokens since they don't have any entitlements.
+func (s *serviceTokenBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Rotate generates a new client secret for the service token. The secret is returned in plaintext
+// and encrypted by Baton with the supplied credential options before it leaves the connector.
+func (s *serviceTokenBuilder) Rotate(
+	ctx context.Context,
+	resourceId *v2.ResourceId,
+	credentialOptions *v2.CredentialOptions,
+) ([]*v2.PlaintextData, annotations.Annotations, error) {
+	token, err := s.client.RotateAccessServiceToken(ctx, cloudflare.AccountIdentifier(s.accountId), resourceId.Resource)
+	if err != nil {
+		return nil, nil, wrapError(err, "failed to rotate access service token")
+	}
+
+	return serviceTokenCredentials(token.ClientID, token.ClientSecret), nil, nil
+}
+
+// Refresh extends the expiry of the service token by its duration without changing its secret.
+func (s *serviceTokenBuilder) Refresh(ctx context.Context, serviceTokenID string) (*v2.Resource, error) {
+	token, err := s.client.RefreshAccessServiceToken(ctx, cloudflare.AccountIdentifier(s.accountId), serviceTokenID)
+	if err != nil {
+		return nil, wrapError(err, "failed to refresh access service token")
+	}
+
+	return newServiceTokenResource(cloudflare.AccessServiceToken{
+		ClientID:  token.ClientID,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		ID:        token.ID,
+		Name:      token.Name,
+		UpdatedAt: token.UpdatedAt,
+		Duration:  token.Duration,
+	}, s.config.expiryWarning)
+}
+
+// Create creates a new service token named after the resource display name. The duration and target
+// group can be overridden with the "duration" and "group_id" fields of the resource profile.
+// Cloudflare only returns the client secret once and the resource manager can't return credentials,
+// so the secret is discarded: use CreateAccount, or rotate the token afterwards, to receive it.
+func (s *serviceTokenBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	var profile *structpb.Struct
+	if trait, err := rs.GetUserTrait(resource); err == nil {
+		profile = trait.Profile
+	}
+
+	ret, _, err := s.createServiceToken(ctx, resource.DisplayName, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ret, nil, nil
+}
+
+// CreateAccount creates a new service token named after the account login and returns its one-time
+// client ID and secret, encrypted by Baton with the supplied credential options. The duration and
+// target group can be overridden with the "duration" and "group_id" fields of the account profile.
+func (s *serviceTokenBuilder) CreateAccount(
+	ctx context.Context,
+	accountInfo *v2.AccountInfo,
+	credentialOptions *v2.CredentialOptions,
+) (connectorbuilder.CreateAccountResponse, []*v2.PlaintextData, annotations.Annotations, error) {
+	ret, credentials, err := s.createServiceToken(ctx, accountInfo.GetLogin(), accountInfo.GetProfile())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &v2.CreateAccountResponse_SuccessResult{
+		Resource:              ret,
+		IsCreateAccountResult: true,
+	}, credentials, nil, nil
+}
+
+// Delete deletes the service token.
+func (s *serviceTokenBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	_, err := s.client.DeleteAccessServiceToken(ctx, cloudflare.AccountIdentifier(s.accountId), resourceId.Resource)
+	if err != nil {
+		return nil, wrapError(err, "failed to delete access service token")
+	}
+
+	return nil, nil
+}
+
+// createServiceToken creates a service token, adds it to the target Access group, if any, and returns
+// its resource and one-time credentials. The token is deleted again when it can't be added to the group.
+func (s *serviceTokenBuilder) createServiceToken(ctx context.Context, name string, profile *structpb.Struct) (*v2.Resource, []*v2.PlaintextData, error) {
+	l := ctxzap.Extract(ctx)
+
+	if name == "" {
+		return nil, nil, fmt.Errorf("baton-cloudflare-zero-trust: a service token name is required")
+	}
+
+	duration := s.config.duration
+	if value, ok := rs.GetProfileStringValue(profile, "duration"); ok && value != "" {
+		duration = value
+	}
+	groupID := s.config.groupID
+	if value, ok := rs.GetProfileStringValue(profile, "group_id"); ok && value != "" {
+		groupID = value
+	}
+
+	created, err := s.client.CreateAccessServiceToken(ctx, cloudflare.AccountIdentifier(s.accountId), cloudflare.CreateAccessServiceTokenParams{
+		Name:     name,
+		Duration: duration,
+	})
+	if err != nil {
+		return nil, nil, wrapError(err, "failed to create access service token")
+	}
+
+	if groupID != "" {
+		rule := map[string]interface{}{"service_token": map[string]interface{}{"token_id": created.ID}}
+		err = addAccessGroupIncludeRule(ctx, s.client, s.accountId, groupID, rule)
+		if err != nil {
+			_, deleteErr := s.client.DeleteAccessServiceToken(ctx, cloudflare.AccountIdentifier(s.accountId), created.ID)
+			if deleteErr != nil {
+				return nil, nil, fmt.Errorf(
+					"baton-cloudflare-zero-trust: failed to add service token %s to group: %w, and failed to delete it: %s",
+					created.ID,
+					err,
+					deleteErr.Error(),
+				)
+			}
+			return nil, nil, fmt.Errorf("baton-cloudflare-zero-trust: failed to add service token to group, the token was deleted: %w", err)
+		}
+	}
+
+	l.Info(
+		"baton-cloudflare-zero-trust: service token created",
+		zap.String("service_token_id", created.ID),
+		zap.String("group_id", groupID),
+	)
+
+	ret, err := newServiceTokenResource(cloudflare.AccessServiceToken{
+		ClientID:  created.ClientID,
+		CreatedAt: created.CreatedAt,
+		ExpiresAt: created.ExpiresAt,
+		ID:        created.ID,
+		Name:      created.Name,
+		UpdatedAt: created.UpdatedAt,
+		Duration:  created.Duration,
+	}, s.config.expiryWarning)
+	if err != nil {
+		return nil, nil, wrapError(err, "failed to create service token resource")
+	}
+
+	return ret, serviceTokenCredentials(created.ClientID, created.ClientSecret), nil
+}
+
+// serviceTokenCredentials returns the plaintext credentials a client needs to authenticate with a service token.
+func serviceTokenCredentials(clientID, clientSecret string) []*v2.PlaintextData {
+	return []*v2.PlaintextData{
+		{
+			Name:        "client_id",
+			Description: "Cloudflare Access service token client ID (CF-Access-Client-Id header)",
+			Bytes:       []byte(clientID),
+		},
+		{
+			Name:        "client_secret",
+			Description: "Cloudflare Access service token client secret (CF-Access-Client-Secret header)",
+			Bytes:       []byte(clientSecret),
+		},
+	}
+}
+
+// RefreshServiceToken extends the expiry of an Access service token without changing its secret
+// and returns the new expiry time.
+func (d *Connector) RefreshServiceToken(ctx context.Context, serviceTokenID string) (string, error) {
+	resource, err := newServiceTokenBuilder(d.client, d.accountId, d.serviceTokens).Refresh(ctx, serviceTokenID)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt, _ := getValueFromUserTrait(resource, "expires_at")
+	return expiresAt, nil
+}
+
+func newServiceTokenBuilder(client *cloudflare.API, accountId string, config serviceTokenConfig) *serviceTokenBuilder {
+	return &serviceTokenBuilder{
+		resourceType: serviceTokenResourceType,
+		client:       client,
+		accountId:    accountId,
+		config:       config,
+	}
+}
@@ -0,0 +1,183 @@
+package connector
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// revokeAccessUserSessions revokes the Access tokens issued to a user, which ends their sessions in
+// every Access application. Cloudflare otherwise only re-evaluates policies when a session expires.
+func revokeAccessUserSessions(ctx context.Context, client *cloudflare.API, accountId string, email string) error {
+	l := ctxzap.Extract(ctx)
+
+	err := client.RevokeAccessUserTokens(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.RevokeAccessUserTokensParams{
+		Email: email,
+	})
+	if err != nil {
+		return wrapError(err, "failed to revoke access user sessions")
+	}
+
+	l.Info("baton-cloudflare-zero-trust: access user sessions revoked", zap.String("email", email))
+
+	return nil
+}
+
+// RevokeSessions logs an Access user, looked up by email or ID, out of every Access application and
+// returns their email.
+func (d *Connector) RevokeSessions(ctx context.Context, user string) (string, error) {
+	email := user
+	if !strings.Contains(user, "@") {
+		accessUser, err := findAccessUser(ctx, d.client, d.accountId, user)
+		if err != nil {
+			return "", err
+		}
+		email = accessUser.Email
+	}
+
+	err := revokeAccessUserSessions(ctx, d.client, d.accountId, email)
+	if err != nil {
+		return "", err
+	}
+
+	return email, nil
+}
+
+// accessUserSession is a live Access session of a user on an application.
+type accessUserSession struct {
+	ID               string
+	AppUID           string
+	AppName          string
+	ExpiresAt        time.Time
+	DeviceID         string
+	IdentityProvider string
+}
+
+// sessionCache looks up the active sessions of Access users.
+type sessionCache = userCache[[]accessUserSession]
+
+func newSessionCache(client *cloudflare.API, accountId string) *sessionCache {
+	return newUserCache(func(ctx context.Context, userID string) ([]accessUserSession, error) {
+		return listAccessUserSessions(ctx, client, accountId, userID)
+	})
+}
+
+// listAccessUserSessions returns a session per application of every active session of the user.
+func listAccessUserSessions(ctx context.Context, client *cloudflare.API, accountId string, userID string) ([]accessUserSession, error) {
+	l := ctxzap.Extract(ctx)
+
+	results, err := client.GetAccessUserActiveSessions(ctx, cloudflare.AccountIdentifier(accountId), userID)
+	if err != nil {
+		return nil, wrapError(err, "failed to list access user active sessions")
+	}
+
+	var rv []accessUserSession
+	for _, result := range results {
+		sessionID := result.Metadata.Nonce
+		if sessionID == "" {
+			sessionID = result.Name
+		}
+
+		expiration := result.Expiration
+		if expiration == 0 {
+			expiration = result.Metadata.Expires
+		}
+
+		var deviceID, identityProvider string
+		session, err := client.GetAccessUserSingleActiveSession(ctx, cloudflare.AccountIdentifier(accountId), userID, sessionID)
+		if err != nil {
+			// The session may have ended in between, its applications are still reported.
+			l.Debug(
+				"baton-cloudflare-zero-trust: failed to get access user session",
+				zap.String("user_id", userID),
+				zap.String("session_id", sessionID),
+				zap.Error(err),
+			)
+		} else {
+			deviceID = session.DeviceID
+			identityProvider = session.IDP.ID
+		}
+
+		for _, app := range result.Metadata.Apps {
+			rv = append(rv, accessUserSession{
+				ID:               sessionID,
+				AppUID:           app.UID,
+				AppName:          app.Name,
+				ExpiresAt:        time.Unix(expiration, 0).UTC(),
+				DeviceID:         deviceID,
+				IdentityProvider: identityProvider,
+			})
+		}
+	}
+
+	return rv, nil
+}
+
+// sessionProfile returns the user profile fields describing the active sessions of a user.
+func sessionProfile(sessions []accessUserSession) map[string]interface{} {
+	ids := make(map[string]bool)
+	apps := make(map[string]bool)
+	var expiresAt time.Time
+	for _, session := range sessions {
+		ids[session.ID] = true
+		apps[session.AppName] = true
+		if session.ExpiresAt.After(expiresAt) {
+			expiresAt = session.ExpiresAt
+		}
+	}
+
+	profile := map[string]interface{}{
+		"active_session_count": len(ids),
+		"active_session_apps":  stringsProfileValue(sortedKeys(apps)),
+	}
+	if !expiresAt.IsZero() {
+		profile["session_expires_at"] = expiresAt.Format(time.RFC3339)
+	}
+
+	return profile
+}
+
+// applicationSessions returns the sessions on an application, identified by its ID or audience tag.
+func applicationSessions(sessions []accessUserSession, appID string, aud string) []accessUserSession {
+	var rv []accessUserSession
+	for _, session := range sessions {
+		if session.AppUID == appID || (aud != "" && session.AppUID == aud) {
+			rv = append(rv, session)
+		}
+	}
+
+	return rv
+}
+
+// addSessionUsage records the live sessions of a user on an application in the metadata of its access grant.
+func addSessionUsage(metadata map[string]interface{}, sessions []accessUserSession) {
+	metadata["active_sessions"] = len(sessions)
+	if len(sessions) == 0 {
+		return
+	}
+
+	var (
+		expiresAt time.Time
+		devices   = make(map[string]bool)
+		idps      = make(map[string]bool)
+	)
+	for _, session := range sessions {
+		if session.ExpiresAt.After(expiresAt) {
+			expiresAt = session.ExpiresAt
+		}
+		if session.DeviceID != "" {
+			devices[session.DeviceID] = true
+		}
+		if session.IdentityProvider != "" {
+			idps[session.IdentityProvider] = true
+		}
+	}
+
+	metadata["session_expires_at"] = expiresAt.Format(time.RFC3339)
+	metadata["session_device_ids"] = stringsProfileValue(sortedKeys(devices))
+	metadata["session_identity_providers"] = stringsProfileValue(sortedKeys(idps))
+}
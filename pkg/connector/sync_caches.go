@@ -0,0 +1,148 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// syncCaches holds the listings of the account that resources of several types depend on. They are
+// fetched once per sync and dropped when the next sync starts.
+type syncCaches struct {
+	accessUsers *syncCache[[]cloudflare.AccessUser]
+	// accessUsersByEmail keys the Access users by normalized email.
+	accessUsersByEmail *syncCache[map[string]cloudflare.AccessUser]
+	serviceTokens      *syncCache[[]cloudflare.AccessServiceToken]
+	evaluator          *syncCache[*policyEvaluator]
+	// applicationsByTag groups the applications of the account, except bookmarks, by tag. Untagged
+	// applications are grouped under the empty tag.
+	applicationsByTag *syncCache[map[string][]cloudflare.AccessApplication]
+	// idpGroups maps the ID of every identity provider group reported in the last seen identities of the
+	// Access users to its members. It is only set when identities are looked up.
+	idpGroups *syncCache[map[string]*idpGroupMembership]
+	// accountMembers maps the ID of every account member to the member.
+	accountMembers *syncCache[map[string]cloudflare.AccountMember]
+	// userStatusSignals holds the account-wide data the user status rules are evaluated against.
+	userStatusSignals *syncCache[*userStatusSignals]
+}
+
+func newSyncCaches(
+	client *cloudflare.API,
+	accountId string,
+	identities *identityCache,
+	userStatus userStatusConfig,
+	syncDevices bool,
+) *syncCaches {
+	caches := &syncCaches{
+		accessUsers: newSyncCache(func(ctx context.Context) ([]cloudflare.AccessUser, error) {
+			users, _, err := client.ListAccessUsers(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.AccessUserParams{})
+			if err != nil {
+				return nil, wrapError(err, "failed to list users")
+			}
+			return users, nil
+		}),
+		serviceTokens: newSyncCache(func(ctx context.Context) ([]cloudflare.AccessServiceToken, error) {
+			tokens, _, err := client.ListAccessServiceTokens(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.ListAccessServiceTokensParams{})
+			if err != nil {
+				return nil, wrapError(err, "failed to list access service tokens")
+			}
+			return tokens, nil
+		}),
+		evaluator: newSyncCache(func(ctx context.Context) (*policyEvaluator, error) {
+			return newPolicyEvaluator(ctx, client, accountId, nil)
+		}),
+		applicationsByTag: newSyncCache(func(ctx context.Context) (map[string][]cloudflare.AccessApplication, error) {
+			apps, _, err := client.ListAccessApplications(ctx, cloudflare.AccountIdentifier(accountId), cloudflare.ListAccessApplicationsParams{})
+			if err != nil {
+				return nil, wrapError(err, "failed to list access applications")
+			}
+			return groupApplicationsByTag(apps), nil
+		}),
+		accountMembers: newSyncCache(func(ctx context.Context) (map[string]cloudflare.AccountMember, error) {
+			return listAccountMembers(ctx, client, accountId)
+		}),
+	}
+
+	caches.userStatusSignals = newSyncCache(func(ctx context.Context) (*userStatusSignals, error) {
+		return getUserStatusSignals(ctx, client, accountId, caches.accountMembers, userStatus, syncDevices)
+	})
+
+	caches.accessUsersByEmail = newSyncCache(func(ctx context.Context) (map[string]cloudflare.AccessUser, error) {
+		users, err := caches.accessUsers.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		rv := make(map[string]cloudflare.AccessUser, len(users))
+		for _, user := range users {
+			rv[normalizeEmail(user.Email)] = user
+		}
+		return rv, nil
+	})
+
+	if identities != nil {
+		caches.idpGroups = newSyncCache(func(ctx context.Context) (map[string]*idpGroupMembership, error) {
+			users, err := caches.accessUsers.get(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return listIDPGroups(ctx, users, identities)
+		})
+	}
+
+	return caches
+}
+
+// reset drops the listings of the previous sync.
+func (s *syncCaches) reset() {
+	s.accessUsers.reset()
+	s.accessUsersByEmail.reset()
+	s.serviceTokens.reset()
+	s.evaluator.reset()
+	s.applicationsByTag.reset()
+	s.accountMembers.reset()
+	s.userStatusSignals.reset()
+	if s.idpGroups != nil {
+		s.idpGroups.reset()
+	}
+}
+
+// accessUsersIncluding returns the Access users by normalized email, listing them again when one of the
+// emails is missing, as users are added when they first log in. Emails of people who never became users
+// show up in denied logins, so the users are listed again at most every userCacheTTL.
+func (s *syncCaches) accessUsersIncluding(ctx context.Context, emails []string) (map[string]cloudflare.AccessUser, error) {
+	users, err := s.accessUsersByEmail.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		if _, ok := users[normalizeEmail(email)]; !ok && email != "" && s.accessUsers.olderThan(userCacheTTL) {
+			s.accessUsers.reset()
+			s.accessUsersByEmail.reset()
+			return s.accessUsersByEmail.get(ctx)
+		}
+	}
+
+	return users, nil
+}
+
+// listAccountMembers returns every member of the account by member ID.
+func listAccountMembers(ctx context.Context, client *cloudflare.API, accountId string) (map[string]cloudflare.AccountMember, error) {
+	rv := make(map[string]cloudflare.AccountMember)
+	for page := 1; ; page++ {
+		members, info, err := client.AccountMembers(ctx, accountId, cloudflare.PaginationOptions{
+			Page:    page,
+			PerPage: resourcePageSize,
+		})
+		if err != nil {
+			return nil, wrapError(err, "failed to list members")
+		}
+		for _, member := range members {
+			rv[member.ID] = member
+		}
+		if info.TotalPages <= info.Page {
+			return rv, nil
+		}
+	}
+}
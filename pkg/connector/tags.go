@@ -0,0 +1,85 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+type tagBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (t *tagBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return t.resourceType
+}
+
+// newTagResource creates a new connector resource for a Cloudflare Access tag.
+// Tags are identified by their name, and the applications carrying them are listed as children.
+func newTagResource(tag cloudflare.AccessTag) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"tag_name":  tag.Name,
+		"app_count": tag.AppCount,
+	}
+
+	groupTraitOptions := []rs.GroupTraitOption{
+		rs.WithGroupProfile(profile),
+	}
+
+	ret, err := rs.NewGroupResource(
+		tag.Name,
+		tagResourceType,
+		tag.Name,
+		groupTraitOptions,
+		rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: applicationResourceType.Id}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// List returns all the Access tags of the account as resource objects.
+func (t *tagBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	tags, err := t.client.ListAccessTags(ctx, cloudflare.AccountIdentifier(t.accountId), cloudflare.ListAccessTagsParams{})
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list access tags")
+	}
+
+	resources := make([]*v2.Resource, 0, len(tags))
+	for _, tag := range tags {
+		resource, err := newTagResource(tag)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create tag resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+// Entitlements always returns an empty slice for tags.
+func (t *tagBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// Grants always returns an empty slice for tags since they don't have any entitlements.
+func (t *tagBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func newTagBuilder(client *cloudflare.API, accountId string) *tagBuilder {
+	return &tagBuilder{
+		resourceType: tagResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}
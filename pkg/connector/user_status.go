@@ -0,0 +1,163 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+)
+
+// User status rules. Each enabled rule can disable a user; users no rule disables are enabled.
+const (
+	// userStatusRuleMemberStatus disables users that are account members whose membership isn't accepted.
+	userStatusRuleMemberStatus = "member_status"
+	// userStatusRuleNoSeat disables users holding neither an Access nor a Gateway seat.
+	userStatusRuleNoSeat = "no_seat"
+	// userStatusRuleDevicesRevoked disables users whose WARP devices are all revoked.
+	userStatusRuleDevicesRevoked = "devices_revoked"
+	// userStatusRuleInactive disables users without activity for longer than the inactivity period.
+	userStatusRuleInactive = "inactive"
+
+	memberStatusAccepted = "accepted"
+)
+
+// UserStatusRules are the available user status rules, in the order they are checked.
+var UserStatusRules = []string{
+	userStatusRuleMemberStatus,
+	userStatusRuleNoSeat,
+	userStatusRuleDevicesRevoked,
+	userStatusRuleInactive,
+}
+
+// DefaultUserStatusRules are the user status rules enabled by default. They only disable users whose
+// account membership isn't live: the other rules disable users that can still log in, such as users
+// without a seat, so they have to be enabled explicitly.
+var DefaultUserStatusRules = []string{
+	userStatusRuleMemberStatus,
+}
+
+// userStatusConfig controls how the status of users is computed.
+type userStatusConfig struct {
+	rules map[string]bool
+	// inactiveAfter is the inactivity period of the inactive rule.
+	inactiveAfter time.Duration
+}
+
+// userStatus is the computed status of a user and the reason it was chosen.
+type userStatus struct {
+	status v2.UserTrait_Status_Status
+	reason string
+}
+
+// userStatusSignals holds the account-wide data the status rules are evaluated against.
+type userStatusSignals struct {
+	// memberStatuses maps the normalized email of account members to their membership status.
+	memberStatuses map[string]string
+	// devices maps user IDs to their WARP devices. It is only set when the devices revoked rule is enabled
+	// or devices are synced, as listing devices needs its own permission.
+	devices map[string][]cloudflare.TeamsDeviceListItem
+}
+
+// newUserStatusConfig validates the rules and returns the configuration.
+func newUserStatusConfig(rules []string, inactiveAfter time.Duration) (userStatusConfig, error) {
+	rv := userStatusConfig{
+		rules:         make(map[string]bool, len(rules)),
+		inactiveAfter: inactiveAfter,
+	}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		if !groupContainsUser(rule, UserStatusRules) {
+			return userStatusConfig{}, fmt.Errorf("baton-cloudflare-zero-trust: unknown user status rule %q, expected one of %s", rule, strings.Join(UserStatusRules, ", "))
+		}
+		rv.rules[rule] = true
+	}
+
+	return rv, nil
+}
+
+// getUserStatusSignals fetches the data needed by the enabled rules, and the devices of users when they
+// are synced. The account members are read from the members listed once per sync.
+func getUserStatusSignals(
+	ctx context.Context,
+	client *cloudflare.API,
+	accountId string,
+	members *syncCache[map[string]cloudflare.AccountMember],
+	config userStatusConfig,
+	syncDevices bool,
+) (*userStatusSignals, error) {
+	rv := &userStatusSignals{}
+
+	if config.rules[userStatusRuleMemberStatus] {
+		accountMembers, err := members.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rv.memberStatuses = accountMemberStatuses(accountMembers)
+	}
+
+	if syncDevices || config.rules[userStatusRuleDevicesRevoked] {
+		devices, err := listUserDevices(ctx, client, accountId)
+		if err != nil {
+			return nil, err
+		}
+		rv.devices = devices
+	}
+
+	return rv, nil
+}
+
+// accountMemberStatuses returns the membership status of every account member by normalized email.
+func accountMemberStatuses(members map[string]cloudflare.AccountMember) map[string]string {
+	rv := make(map[string]string, len(members))
+	for _, member := range members {
+		rv[normalizeEmail(member.User.Email)] = member.Status
+	}
+
+	return rv
+}
+
+// evaluateUserStatus applies the enabled rules in order. The first rule that disables the user decides.
+func evaluateUserStatus(user cloudflare.AccessUser, signals *userStatusSignals, config userStatusConfig, now time.Time) userStatus {
+	disabled := func(reason string) userStatus {
+		return userStatus{status: v2.UserTrait_Status_STATUS_DISABLED, reason: reason}
+	}
+
+	if config.rules[userStatusRuleMemberStatus] && signals != nil {
+		if status, ok := signals.memberStatuses[normalizeEmail(user.Email)]; ok && status != memberStatusAccepted {
+			return disabled(fmt.Sprintf("account membership is %s", status))
+		}
+	}
+
+	if config.rules[userStatusRuleNoSeat] {
+		accessSeat := user.AccessSeat != nil && *user.AccessSeat
+		gatewaySeat := user.GatewaySeat != nil && *user.GatewaySeat
+		if !accessSeat && !gatewaySeat {
+			return disabled("holds no Access or Gateway seat")
+		}
+	}
+
+	if config.rules[userStatusRuleDevicesRevoked] && signals != nil {
+		total, active := deviceCounts(signals.devices[user.ID])
+		if total > 0 && active == 0 {
+			return disabled(fmt.Sprintf("all %d WARP devices are revoked", total))
+		}
+	}
+
+	if config.rules[userStatusRuleInactive] && config.inactiveAfter > 0 {
+		last, ok := lastAccessUserActivity(user)
+		if !ok {
+			return disabled("no recorded activity")
+		}
+		if now.Sub(last) > config.inactiveAfter {
+			return disabled(fmt.Sprintf("inactive since %s", last.Format(time.RFC3339)))
+		}
+	}
+
+	return userStatus{status: v2.UserTrait_Status_STATUS_ENABLED, reason: "active"}
+}
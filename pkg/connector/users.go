@@ -16,6 +16,29 @@ type userBuilder struct {
 	resourceType *v2.ResourceType
 	client       *cloudflare.API
 	accountId    string
+	// sessions enriches users with their active sessions when set.
+	sessions     *sessionCache
+	failedLogins failedLoginConfig
+	status       userStatusConfig
+	// identities attributes users to the identity provider they last authenticated with when set.
+	identities *identityCache
+	caches     *syncCaches
+}
+
+// userDetails holds the optional data users are enriched with during syncs.
+type userDetails struct {
+	// sessions are the active sessions of the user, set when sessions are synced.
+	sessions []accessUserSession
+	// failedLogins summarises the recent failed logins of the user, set when they are synced.
+	failedLogins         *failedLoginSummary
+	failedLoginThreshold int
+	// status is the status computed by the user status rules.
+	status *userStatus
+	// devices are the WARP devices enrolled for the user.
+	devices []cloudflare.TeamsDeviceListItem
+	// identityProvider is the identity provider the user last authenticated with, set when users are
+	// attributed to identity providers. Its ID is empty for users that never logged in.
+	identityProvider *cloudflare.AccessUserIDP
 }
 
 func (o *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
@@ -23,18 +46,59 @@ func (o *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 }
 
 func newUserResource(user cloudflare.AccessUser) (*v2.Resource, error) {
+	return newDetailedUserResource(user, nil)
+}
+
+// newDetailedUserResource creates a user resource enriched with the optional details, if any.
+func newDetailedUserResource(user cloudflare.AccessUser, details *userDetails) (*v2.Resource, error) {
 	firstName, lastName := helpers.SplitFullName(user.Name)
 	profile := map[string]interface{}{
-		"login":       user.Email,
-		"first_name":  firstName,
-		"last_name":   lastName,
-		"email":       user.Email,
-		"access_seat": *user.AccessSeat,
+		"login":        user.Email,
+		"first_name":   firstName,
+		"last_name":    lastName,
+		"email":        user.Email,
+		"access_seat":  user.AccessSeat != nil && *user.AccessSeat,
+		"gateway_seat": user.GatewaySeat != nil && *user.GatewaySeat,
+	}
+	status := v2.UserTrait_Status_STATUS_UNSPECIFIED
+	if details != nil && details.status != nil {
+		status = details.status.status
+		profile["status_reason"] = details.status.reason
+	}
+	if details != nil && details.devices != nil {
+		deviceCount, activeDeviceCount := deviceCounts(details.devices)
+		profile["device_count"] = deviceCount
+		profile["active_device_count"] = activeDeviceCount
+	}
+	if details != nil && (details.sessions != nil || details.failedLogins != nil || details.identityProvider != nil) {
+		var riskFlags []string
+		if details.sessions != nil {
+			for k, v := range sessionProfile(details.sessions) {
+				profile[k] = v
+			}
+		}
+		if details.failedLogins != nil {
+			failedLogins, atRisk := failedLoginProfile(details.failedLogins, details.failedLoginThreshold)
+			for k, v := range failedLogins {
+				profile[k] = v
+			}
+			if atRisk {
+				riskFlags = append(riskFlags, riskFailedLogins)
+			}
+		}
+		if details.identityProvider != nil && details.identityProvider.ID != "" {
+			profile["identity_provider_id"] = details.identityProvider.ID
+			profile["identity_provider_type"] = details.identityProvider.Type
+			if details.identityProvider.Type == identityProviderOneTimePin {
+				riskFlags = append(riskFlags, riskOneTimePin)
+			}
+		}
+		profile["risk_flags"] = stringsProfileValue(riskFlags)
 	}
 
 	userTraits := []rs.UserTraitOption{
 		rs.WithUserProfile(profile),
-		rs.WithStatus(v2.UserTrait_Status_STATUS_UNSPECIFIED),
+		rs.WithStatus(status),
 		rs.WithUserLogin(user.Email),
 		rs.WithEmail(user.Email, true),
 	}
@@ -84,9 +148,26 @@ func (o *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId,
 		return nil, "", nil, wrapError(err, "failed to list users")
 	}
 
+	signals, err := o.caches.userStatusSignals.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	now := time.Now()
 	resources := make([]*v2.Resource, 0, len(users))
 	for _, user := range users {
-		resource, err := newUserResource(user)
+		details, err := o.getUserDetails(ctx, user)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		status := evaluateUserStatus(user, signals, o.status, now)
+		details.status = &status
+		if signals.devices != nil {
+			// An empty, non-nil slice records that the user has no device.
+			details.devices = append([]cloudflare.TeamsDeviceListItem{}, signals.devices[user.ID]...)
+		}
+
+		resource, err := newDetailedUserResource(user, details)
 		if err != nil {
 			return nil, "", nil, wrapError(err, "failed to create user resource")
 		}
@@ -106,6 +187,40 @@ func (o *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId,
 	return resources, nextPage, nil, nil
 }
 
+// getUserDetails returns the optional details of a user that are synced.
+func (o *userBuilder) getUserDetails(ctx context.Context, user cloudflare.AccessUser) (*userDetails, error) {
+	details := &userDetails{failedLoginThreshold: o.failedLogins.threshold}
+	if o.sessions != nil {
+		sessions, err := o.sessions.get(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		// An empty, non-nil slice records that the user has no active session.
+		details.sessions = append([]accessUserSession{}, sessions...)
+	}
+
+	if o.identities != nil {
+		identity, err := o.identities.get(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		details.identityProvider = &cloudflare.AccessUserIDP{}
+		if identity != nil {
+			details.identityProvider = &identity.IDP
+		}
+	}
+
+	if o.failedLogins.enabled {
+		summary, err := getFailedLoginSummary(ctx, o.client, o.accountId, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		details.failedLogins = summary
+	}
+
+	return details, nil
+}
+
 // Entitlements always returns an empty slice for users.
 func (o *userBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
 	return nil, "", nil, nil
@@ -116,10 +231,23 @@ func (o *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 	return nil, "", nil, nil
 }
 
-func newUserBuilder(client *cloudflare.API, accountId string) *userBuilder {
+func newUserBuilder(
+	client *cloudflare.API,
+	accountId string,
+	sessions *sessionCache,
+	failedLogins failedLoginConfig,
+	status userStatusConfig,
+	identities *identityCache,
+	caches *syncCaches,
+) *userBuilder {
 	return &userBuilder{
 		resourceType: userResourceType,
 		client:       client,
 		accountId:    accountId,
+		sessions:     sessions,
+		failedLogins: failedLogins,
+		status:       status,
+		identities:   identities,
+		caches:       caches,
 	}
 }
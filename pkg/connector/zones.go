@@ -0,0 +1,94 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+type zoneBuilder struct {
+	resourceType *v2.ResourceType
+	client       *cloudflare.API
+	accountId    string
+}
+
+func (z *zoneBuilder) ResourceType(_ context.Context) *v2.ResourceType {
+	return z.resourceType
+}
+
+// newZoneResource creates a new connector resource for a Cloudflare zone of the account.
+func newZoneResource(zone cloudflare.Zone, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	return rs.NewResource(
+		zone.Name,
+		zoneResourceType,
+		zone.ID,
+		rs.WithParentResourceID(parentResourceID),
+	)
+}
+
+// List returns the zones of the account as children of the account resource.
+func (z *zoneBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil {
+		return nil, "", nil, nil
+	}
+
+	zones, err := z.client.ListZonesContext(ctx, cloudflare.WithZoneFilters("", z.accountId, ""))
+	if err != nil {
+		return nil, "", nil, wrapError(err, "failed to list zones")
+	}
+
+	resources := make([]*v2.Resource, 0, len(zones.Result))
+	for _, zone := range zones.Result {
+		resource, err := newZoneResource(zone, parentResourceID)
+		if err != nil {
+			return nil, "", nil, wrapError(err, "failed to create zone resource")
+		}
+
+		resources = append(resources, resource)
+	}
+
+	return resources, "", nil, nil
+}
+
+// Entitlements returns a permission entitlement for every API token permission group that applies to zones.
+func (z *zoneBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	rv, err := permissionGroupEntitlements(ctx, z.client, resource, apiTokenZoneScope)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grants returns the permission groups that API token policies allow on the zone.
+func (z *zoneBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return apiTokenPermissionGrants(ctx, z.client, resource, pToken, func(resources map[string]interface{}) bool {
+		return apiTokenCoversZone(resources, z.accountId, resource.Id.Resource)
+	})
+}
+
+// Grant isn't supported: API token permissions are managed by the token owner.
+func (z *zoneBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	return nil, fmt.Errorf("baton-cloudflare-zero-trust: api token permissions can't be granted by the connector")
+}
+
+// Revoke removes the permission on the zone from the policies of the API token holding it.
+func (z *zoneBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	zoneID := grant.Entitlement.Resource.Id.Resource
+	return revokeAPITokenPermission(ctx, z.client, grant, apiTokenZoneScope+"."+zoneID, func(resources map[string]interface{}) bool {
+		return apiTokenCoversZone(resources, z.accountId, zoneID)
+	})
+}
+
+func newZoneBuilder(client *cloudflare.API, accountId string) *zoneBuilder {
+	return &zoneBuilder{
+		resourceType: zoneResourceType,
+		client:       client,
+		accountId:    accountId,
+	}
+}